@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+// Generates a JWT signing key as PEM for JWT_SIGNING_KEYS or JWT_KEYS_DIR.
+// Schedule a rotation by generating the next key with a future -activates time
+// and deploying it alongside the current one.
+func main() {
+	alg := flag.String("alg", crypto.AlgEdDSA, "signing algorithm: EdDSA or ES256")
+	kid := flag.String("kid", "", "key ID (default: derived from the activation time)")
+	activates := flag.String("activates", "", "activation time, RFC3339 or a duration from now such as 720h (default: now)")
+	flag.Parse()
+
+	activatesAt := time.Now().UTC()
+	if *activates != "" {
+		if d, err := time.ParseDuration(*activates); err == nil {
+			activatesAt = activatesAt.Add(d)
+		} else if t, err := time.Parse(time.RFC3339, *activates); err == nil {
+			activatesAt = t.UTC()
+		} else {
+			log.Fatalf("invalid -activates %q", *activates)
+		}
+	}
+	activatesAt = activatesAt.Truncate(time.Second)
+
+	if *kid == "" {
+		*kid = activatesAt.Format("20060102T150405Z")
+	}
+
+	key, err := crypto.GenerateSigningKey(*alg, *kid, activatesAt)
+	if err != nil {
+		log.Fatalf("failed to generate key: %v", err)
+	}
+
+	data, err := crypto.EncodeSigningKeyPEM(key)
+	if err != nil {
+		log.Fatalf("failed to encode key: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Generated %s key %s, active from %s\n", key.Algorithm, key.ID, activatesAt.Format(time.RFC3339))
+	os.Stdout.Write(data)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/snaptalker/backend/internal/auth"
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+// signingKeyReloadInterval is how often key files are re-read so scheduled
+// keys can be added without a restart
+const signingKeyReloadInterval = 10 * time.Minute
+
+// loadSigningKeys builds the token signing key ring from JWT_SIGNING_KEYS and
+// JWT_KEYS_DIR. Outside production an ephemeral key is generated when none is
+// configured; in production that is a startup error.
+func loadSigningKeys(config Config) (*crypto.KeyRing, error) {
+	keys, err := readSigningKeys(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		if config.Environment == "production" {
+			return nil, fmt.Errorf("no JWT signing keys configured, set JWT_SIGNING_KEYS or JWT_KEYS_DIR")
+		}
+		log.Println("Warning: no JWT signing keys configured, using an ephemeral key (tokens will not survive a restart)")
+		key, err := crypto.GenerateSigningKey(crypto.AlgEdDSA, "dev-"+time.Now().UTC().Format("20060102150405"), time.Now())
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	ring, err := crypto.NewKeyRing(keys, auth.SigningKeyOverlap)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ring.Current(time.Now()); err != nil {
+		return nil, fmt.Errorf("no JWT signing key is active yet: %w", err)
+	}
+	return ring, nil
+}
+
+// reloadSigningKeys periodically re-reads the configured key material
+func reloadSigningKeys(ctx context.Context, ring *crypto.KeyRing, config Config) {
+	if config.JWTKeysDir == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(signingKeyReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			keys, err := readSigningKeys(config)
+			if err == nil {
+				err = ring.SetKeys(keys)
+			}
+			if err != nil {
+				log.Printf("Failed to reload JWT signing keys, keeping previous keys: %v", err)
+			}
+		}
+	}()
+}
+
+// minLinkSecretLength is the shortest accepted LINK_SECRET
+const minLinkSecretLength = 32
+
+// loadLinkSecret returns the HMAC secret for shareable links from
+// LINK_SECRET. Outside production a random secret is generated when none is
+// configured, which invalidates links on restart.
+func loadLinkSecret(config Config) ([]byte, error) {
+	if config.LinkSecret != "" {
+		if len(config.LinkSecret) < minLinkSecretLength {
+			return nil, fmt.Errorf("LINK_SECRET must be at least %d characters", minLinkSecretLength)
+		}
+		return []byte(config.LinkSecret), nil
+	}
+	if config.Environment == "production" {
+		return nil, fmt.Errorf("no link secret configured, set LINK_SECRET")
+	}
+	log.Println("Warning: LINK_SECRET not configured, using an ephemeral secret (shared links will not survive a restart)")
+	return crypto.GenerateRandomBytes(32)
+}
+
+func readSigningKeys(config Config) ([]*crypto.SigningKey, error) {
+	var keys []*crypto.SigningKey
+	if config.JWTSigningKeys != "" {
+		inline, err := crypto.ParseSigningKeysPEM([]byte(config.JWTSigningKeys), "")
+		if err != nil {
+			return nil, fmt.Errorf("JWT_SIGNING_KEYS: %w", err)
+		}
+		keys = append(keys, inline...)
+	}
+	if config.JWTKeysDir != "" {
+		fromDir, err := crypto.LoadSigningKeysDir(config.JWTKeysDir)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_KEYS_DIR: %w", err)
+		}
+		keys = append(keys, fromDir...)
+	}
+	return keys, nil
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	ossignal "os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -14,8 +15,12 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/snaptalker/backend/internal/auth"
 	"github.com/snaptalker/backend/internal/calls"
+	"github.com/snaptalker/backend/internal/email"
 	"github.com/snaptalker/backend/internal/messaging"
+	"github.com/snaptalker/backend/internal/privacy"
+	"github.com/snaptalker/backend/internal/security"
 	"github.com/snaptalker/backend/internal/signal"
+	"github.com/snaptalker/backend/pkg/crypto"
 	"github.com/snaptalker/backend/pkg/storage"
 )
 
@@ -83,18 +88,89 @@ func main() {
 		log.Println("MinIO not configured, media storage disabled")
 	}
 
+	// Initialize OTP delivery
+	emailService := email.NewService()
+	otpSender, err := auth.NewOTPSender(config.OTP, emailService)
+	if err != nil {
+		log.Fatalf("Failed to configure OTP provider: %v", err)
+	}
+	if config.Environment == "production" && (config.OTP.Provider == "log" || config.OTP.Provider == "") {
+		log.Fatal("OTP_PROVIDER must be sms or email in production")
+	}
+
+	if !auth.ValidRegistrationMode(config.RegistrationMode) {
+		log.Fatalf("REGISTRATION_MODE must be open, invite or closed, got %q", config.RegistrationMode)
+	}
+
+	// Load token signing keys
+	signingKeys, err := loadSigningKeys(config)
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	reloadSigningKeys(context.Background(), signingKeys, config)
+
+	linkSecret, err := loadLinkSecret(config)
+	if err != nil {
+		log.Fatalf("Failed to load link secret: %v", err)
+	}
+
+	passwordHasher, passwordPolicy, err := loadPasswordHashing(config)
+	if err != nil {
+		log.Fatalf("Failed to configure password hashing: %v", err)
+	}
+
+	oidcProviders, err := loadOIDCProviders(config)
+	if err != nil {
+		log.Fatalf("Failed to configure single sign-on: %v", err)
+	}
+
 	// Initialize services
-	authService := auth.NewService(db, redisClient, config.JWTSecret)
-	signalService := signal.NewService(db, redisClient)
-	messagingService := messaging.NewService(db, redisClient, minioClient)
-	callsService := calls.NewService(redisClient)
+	privacyService := privacy.NewService(db)
+	securityLog := security.NewLog(db, config.SecurityEventRetention)
+	authService := auth.NewService(db, redisClient, minioClient, otpSender, emailService, auth.Config{
+		SigningKeys:         signingKeys,
+		DeletionGracePeriod: config.DeletionGracePeriod,
+		Privacy:             privacyService,
+		LinkSecret:          linkSecret,
+		PublicURL:           config.PublicURL,
+		PasswordHasher:      passwordHasher,
+		PasswordPolicy:      passwordPolicy,
+		OIDCProviders:       oidcProviders,
+		SecurityLog:         securityLog,
+		RegistrationMode:    config.RegistrationMode,
+		InviteQuota:         config.InviteQuota,
+	})
+	signalService := signal.NewService(db, redisClient, securityLog)
+	messagingService := messaging.NewService(db, redisClient, minioClient, privacyService)
+	callsService := calls.NewService(db, redisClient, privacyService)
+
+	// Drop security events past their retention period
+	securityLog.StartRetentionWorker(context.Background(), time.Hour)
+
+	// Purge registrations that were never verified
+	authService.StartPendingAccountPurger(context.Background(), time.Hour, config.PendingAccountTTL)
+
+	// Purge deleted accounts once their grace period has elapsed, and expired data exports
+	authService.StartAccountDeletionWorker(context.Background(), time.Hour)
+
+	// Drop realtime connections of revoked sessions
+	authService.OnSessionRevoked(messagingService.DisconnectSession)
+	authService.OnSessionRevoked(callsService.DisconnectSession)
+	authService.OnAccountDisabled(messagingService.DisconnectUser)
+	authService.OnAccountDisabled(callsService.DisconnectUser)
+
+	// Tell conversation partners about key, profile and phone number changes and deleted accounts
+	authService.OnContactEvent(messagingService.BroadcastToContacts)
+	authService.OnUserEvent(func(userID string, event map[string]interface{}) {
+		messagingService.SendToUser(userID, event)
+	})
 
 	// Initialize router
 	router := gin.Default()
 
 	// CORS configuration
 	corsConfig := cors.Config{
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
@@ -136,18 +212,35 @@ func main() {
 		})
 	})
 
+	// Public token verification keys
+	router.GET("/.well-known/jwks.json", authService.JWKS)
+
 	// API routes
 	v1 := router.Group("/api/v1")
 	{
 		// Authentication
 		authGroup := v1.Group("/auth")
 		{
+			authGroup.GET("/registration", authService.GetRegistrationMode)
 			authGroup.POST("/register", authService.Register)
 			authGroup.POST("/login", authService.Login)
+			authGroup.POST("/login/2fa", authService.LoginTwoFactor)
+			authGroup.POST("/login/email", authService.RequestEmailLogin)
+			authGroup.POST("/login/email/verify", authService.VerifyEmailLogin)
 			authGroup.POST("/verify", authService.VerifyOTP)
+			authGroup.POST("/resend-otp", authService.ResendOTP)
 			authGroup.POST("/refresh", authService.RefreshToken)
 			authGroup.POST("/forgot-password", authService.ForgotPassword)
 			authGroup.POST("/reset-password", authService.ResetPassword)
+			authGroup.POST("/cancel-deletion", authService.CancelAccountDeletion)
+			authGroup.POST("/verify-email", authService.VerifyEmail)
+			authGroup.POST("/report-login", authService.ReportLogin)
+			authGroup.POST("/devices/link", authService.LinkDevice)
+			authGroup.GET("/oidc/providers", authService.GetOIDCProviders)
+			authGroup.POST("/oidc/:provider/start", authService.StartOIDCLogin)
+			authGroup.POST("/oidc/:provider/callback", authService.CompleteOIDCLogin)
+			authGroup.POST("/logout", authService.AuthMiddleware(), authService.Logout)
+			authGroup.POST("/logout-all", authService.AuthMiddleware(), authService.LogoutAll)
 		}
 
 		// Protected routes (require authentication)
@@ -192,11 +285,68 @@ func main() {
 			{
 				usersGroup.GET("/me", authService.GetCurrentUser)
 				usersGroup.PUT("/me", authService.UpdateProfile)
+				usersGroup.PATCH("/me", authService.UpdateProfile)
+				usersGroup.PUT("/me/avatar", authService.UploadAvatar)
+				usersGroup.DELETE("/me/avatar", authService.DeleteAvatar)
+				usersGroup.DELETE("/me", authService.DeleteAccount)
+				usersGroup.POST("/me/export", authService.RequestDataExport)
+				usersGroup.GET("/me/export", authService.GetDataExport)
+				usersGroup.POST("/me/email/verify", authService.ResendEmailVerification)
+				usersGroup.PUT("/me/password", authService.ChangePassword)
+				usersGroup.POST("/me/phone", authService.RequestPhoneChange)
+				usersGroup.POST("/me/phone/verify", authService.VerifyPhoneChange)
+				usersGroup.GET("/me/privacy", privacyService.GetSettings)
+				usersGroup.PATCH("/me/privacy", privacyService.UpdateSettings)
+				usersGroup.GET("/me/sessions", authService.GetSessions)
+				usersGroup.DELETE("/me/sessions/:sessionId", authService.TerminateSession)
+				usersGroup.GET("/me/devices", authService.GetDevices)
+				usersGroup.POST("/me/devices/pairing", authService.CreateDevicePairing)
+				usersGroup.DELETE("/me/devices/:deviceId", authService.UnlinkDevice)
+				usersGroup.GET("/me/security-events", securityLog.GetMyEvents)
+				usersGroup.GET("/me/invites", authService.GetMyInvites)
+				usersGroup.POST("/me/invites", authService.CreateInvite)
+				usersGroup.DELETE("/me/invites/:code", authService.RevokeInvite)
+				usersGroup.POST("/me/2fa/totp/setup", authService.SetupTOTP)
+				usersGroup.POST("/me/2fa/totp/enable", authService.EnableTOTP)
+				usersGroup.DELETE("/me/2fa/totp", authService.DisableTOTP)
+				usersGroup.POST("/me/2fa/recovery-codes", authService.RegenerateRecoveryCodes)
+				usersGroup.PUT("/me/2fa/registration-lock", authService.SetRegistrationLock)
+				usersGroup.DELETE("/me/2fa/registration-lock", authService.RemoveRegistrationLock)
+				usersGroup.GET("/blocked", privacyService.GetBlockedUsers)
+				usersGroup.POST("/blocked", privacyService.BlockUser)
+				usersGroup.DELETE("/blocked/:userId", privacyService.UnblockUser)
+				usersGroup.PUT("/me/handle", authService.SetHandle)
+				usersGroup.DELETE("/me/handle", authService.RemoveHandle)
+				usersGroup.GET("/me/link", authService.GetProfileLink)
+				usersGroup.POST("/me/link/reset", authService.ResetProfileLink)
+				usersGroup.GET("/by-handle/:handle", authService.GetUserByHandle)
+				usersGroup.GET("/by-link/:token", authService.ResolveProfileLink)
 				usersGroup.GET("/search", authService.SearchUsers)
+				usersGroup.POST("/discover", authService.DiscoverContacts)
 				usersGroup.GET("/:userId", authService.GetUserProfile)
 				usersGroup.GET("/online-status", authService.GetOnlineStatus)
 				usersGroup.POST("/heartbeat", authService.UpdateOnlineStatus)
 			}
+
+			// Operator moderation routes; every action is audit-logged
+			adminGroup := protected.Group("/admin")
+			adminGroup.Use(authService.AdminMiddleware())
+			{
+				adminGroup.GET("/users", authService.AdminSearchUsers)
+				adminGroup.GET("/users/:userId", authService.AdminGetUser)
+				adminGroup.POST("/users/:userId/suspend", authService.AdminSuspendUser)
+				adminGroup.POST("/users/:userId/unsuspend", authService.AdminUnsuspendUser)
+				adminGroup.POST("/users/:userId/ban", authService.AdminBanUser)
+				adminGroup.POST("/users/:userId/logout", authService.AdminLogoutUser)
+				adminGroup.POST("/users/:userId/reset-2fa", authService.AdminResetTwoFactor)
+				adminGroup.GET("/reports", authService.AdminGetReports)
+				adminGroup.GET("/reports/:reportId", authService.AdminGetReport)
+				adminGroup.PATCH("/reports/:reportId", authService.AdminUpdateReport)
+				adminGroup.GET("/invites", authService.AdminGetInvites)
+				adminGroup.POST("/invites", authService.AdminMintInvites)
+				adminGroup.DELETE("/invites/:code", authService.AdminRevokeInvite)
+				adminGroup.GET("/audit-log", authService.AdminGetAuditLog)
+			}
 		}
 	}
 
@@ -246,17 +396,66 @@ func main() {
 // Config holds application configuration
 type Config struct {
 	DatabaseURL string
-	JWTSecret   string
 	Environment string
 	Port        string
+	// JWTSigningKeys holds PEM signing keys inline, JWTKeysDir a directory of
+	// *.pem key files; both may be combined
+	JWTSigningKeys string
+	JWTKeysDir     string
+	// LinkSecret signs shareable links; PublicURL is where they point
+	LinkSecret string
+	PublicURL  string
+	OTP        auth.OTPConfig
+	// Argon2 are the cost parameters for new password hashes;
+	// BreachedPasswordsFile lists SHA-1 hashes of passwords to refuse
+	Argon2                crypto.Argon2Params
+	PasswordMinLength     int
+	BreachedPasswordsFile string
+	// OIDCProviders is a JSON array of identity providers for single sign-on
+	OIDCProviders string
+	// PendingAccountTTL is how long unverified registrations are kept
+	PendingAccountTTL time.Duration
+	// DeletionGracePeriod is how long deleted accounts can be restored
+	DeletionGracePeriod time.Duration
+	// SecurityEventRetention is how long security events are kept
+	SecurityEventRetention time.Duration
+	// RegistrationMode is open, invite or closed; InviteQuota is how many
+	// invite codes each user may create
+	RegistrationMode string
+	InviteQuota      int
 }
 
 func loadConfig() Config {
 	return Config{
-		DatabaseURL: getEnv("DATABASE_URL", "sqlite://snaptalker.db"),
-		JWTSecret:   getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
-		Environment: getEnv("ENVIRONMENT", "development"),
-		Port:        getEnv("PORT", defaultPort),
+		DatabaseURL:    getEnv("DATABASE_URL", "sqlite://snaptalker.db"),
+		Environment:    getEnv("ENVIRONMENT", "development"),
+		Port:           getEnv("PORT", defaultPort),
+		JWTSigningKeys: getEnv("JWT_SIGNING_KEYS", ""),
+		JWTKeysDir:     getEnv("JWT_KEYS_DIR", ""),
+		LinkSecret:     getEnv("LINK_SECRET", ""),
+		PublicURL:      getEnv("PUBLIC_APP_URL", "https://snaptalker.vercel.app"),
+		OTP: auth.OTPConfig{
+			Provider:      getEnv("OTP_PROVIDER", "log"),
+			SMSGatewayURL: getEnv("SMS_GATEWAY_URL", ""),
+			SMSAPIKey:     getEnv("SMS_GATEWAY_API_KEY", ""),
+			SMSSenderID:   getEnv("SMS_SENDER_ID", "SNAPTK"),
+			LogFile:       getEnv("OTP_LOG_FILE", ""),
+		},
+		Argon2: crypto.Argon2Params{
+			Memory:     uint32(getEnvInt("PASSWORD_ARGON2_MEMORY_KIB", int(crypto.DefaultArgon2Params.Memory))),
+			Time:       uint32(getEnvInt("PASSWORD_ARGON2_TIME", int(crypto.DefaultArgon2Params.Time))),
+			Threads:    uint8(getEnvInt("PASSWORD_ARGON2_THREADS", int(crypto.DefaultArgon2Params.Threads))),
+			SaltLength: crypto.DefaultArgon2Params.SaltLength,
+			KeyLength:  crypto.DefaultArgon2Params.KeyLength,
+		},
+		PasswordMinLength:      getEnvInt("PASSWORD_MIN_LENGTH", auth.DefaultMinPasswordLength),
+		BreachedPasswordsFile:  getEnv("BREACHED_PASSWORDS_FILE", ""),
+		OIDCProviders:          getEnv("OIDC_PROVIDERS", ""),
+		PendingAccountTTL:      getEnvDuration("PENDING_ACCOUNT_TTL", 24*time.Hour),
+		DeletionGracePeriod:    getEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", 7*24*time.Hour),
+		SecurityEventRetention: getEnvDuration("SECURITY_EVENT_RETENTION", security.DefaultRetention),
+		RegistrationMode:       getEnv("REGISTRATION_MODE", auth.RegistrationOpen),
+		InviteQuota:            getEnvInt("INVITE_QUOTA", auth.DefaultInviteQuota),
 	}
 }
 
@@ -266,3 +465,29 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("Invalid number for %s (%q), using default %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s (%q), using default %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
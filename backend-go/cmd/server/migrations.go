@@ -112,6 +112,412 @@ func runMigrations(db *storage.PostgresDB) error {
 	db.Exec(`CREATE INDEX IF NOT EXISTS idx_reactions_message_id ON message_reactions(message_id)`)
 	db.Exec(`CREATE INDEX IF NOT EXISTS idx_reactions_user_id ON message_reactions(user_id)`)
 
+	// Create sessions table (one row per logged-in device / refresh token family)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			device_name TEXT,
+			platform TEXT,
+			user_agent TEXT,
+			ip_address TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP,
+			revoked_reason TEXT
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create sessions table: %v", err)
+		return err
+	}
+
+	// Create refresh tokens table (hashed, rotated on every use)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS refresh_tokens (
+			token_hash TEXT PRIMARY KEY,
+			session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create refresh_tokens table: %v", err)
+		return err
+	}
+
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id) WHERE revoked_at IS NULL`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens(session_id)`)
+
+	// Add two-step verification and registration lock columns to users table
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN DEFAULT FALSE`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT DEFAULT 0`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS registration_lock_hash TEXT`)
+
+	// Create recovery codes table (single-use, stored hashed)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			used_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create recovery_codes table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id)`)
+
+	// Create pending re-registrations table (new device credentials awaiting OTP)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS pending_reregistrations (
+			phone TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			password_hash TEXT NOT NULL,
+			identity_key TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create pending_reregistrations table: %v", err)
+		return err
+	}
+
+	// Add account status (existing accounts predate verification tracking and stay active)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active'`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_users_pending ON users(created_at) WHERE status = 'pending'`)
+
+	// Create OTP codes table (hashed, bound to a purpose, single-use)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS otp_codes (
+			id TEXT PRIMARY KEY,
+			identifier TEXT NOT NULL,
+			purpose TEXT NOT NULL,
+			code_hash TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			consumed_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create otp_codes table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_otp_codes_identifier ON otp_codes(identifier, purpose) WHERE consumed_at IS NULL`)
+
+	// Add deletion schedule for accounts within their deletion grace period
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMP`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_users_deletion ON users(deletion_scheduled_at) WHERE status = 'deleted'`)
+
+	// Create call log table (call metadata only, no media or SDP)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS call_logs (
+			id TEXT PRIMARY KEY,
+			caller_id TEXT NOT NULL,
+			callee_id TEXT NOT NULL,
+			call_type TEXT NOT NULL DEFAULT 'audio',
+			status TEXT NOT NULL,
+			started_at TIMESTAMP NOT NULL,
+			answered_at TIMESTAMP,
+			ended_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create call_logs table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_call_logs_caller ON call_logs(caller_id, started_at DESC)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_call_logs_callee ON call_logs(callee_id, started_at DESC)`)
+
+	// Create data export jobs table ("Request account info")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS data_exports (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			status TEXT NOT NULL,
+			object_name TEXT,
+			size_bytes BIGINT,
+			error TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			completed_at TIMESTAMP,
+			expires_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create data_exports table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports(user_id, created_at DESC)`)
+
+	// Create pending phone number changes table (awaiting OTP on both numbers)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS pending_phone_changes (
+			user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			new_phone TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create pending_phone_changes table: %v", err)
+		return err
+	}
+
+	// Add profile columns (avatar_key is the MinIO prefix holding every avatar size)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS about TEXT`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_key TEXT`)
+
+	// Create privacy settings tables (users without a row use the defaults)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS privacy_settings (
+			user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			last_seen TEXT NOT NULL DEFAULT 'everyone',
+			online TEXT NOT NULL DEFAULT 'everyone',
+			profile_photo TEXT NOT NULL DEFAULT 'everyone',
+			about TEXT NOT NULL DEFAULT 'everyone',
+			read_receipts TEXT NOT NULL DEFAULT 'everyone',
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create privacy_settings table: %v", err)
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS privacy_exceptions (
+			user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			setting TEXT NOT NULL,
+			excluded_user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			PRIMARY KEY (user_id, setting, excluded_user_id)
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create privacy_exceptions table: %v", err)
+		return err
+	}
+
+	// Create block list and user reports tables
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS blocked_users (
+			user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			blocked_user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, blocked_user_id)
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create blocked_users table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_blocked_users_blocked ON blocked_users(blocked_user_id)`)
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_reports (
+			id TEXT PRIMARY KEY,
+			reporter_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			reported_user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			reason TEXT,
+			messages JSONB NOT NULL DEFAULT '[]',
+			status TEXT NOT NULL DEFAULT 'open',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create user_reports table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_user_reports_status ON user_reports(status, created_at DESC)`)
+
+	// Add phone number hashes for contact discovery (hex SHA-256 of the E.164 number)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_hash TEXT`)
+	db.Exec(`UPDATE users SET phone_hash = encode(sha256(convert_to(phone, 'UTF8')), 'hex') WHERE phone_hash IS NULL`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_users_phone_hash ON users(phone_hash text_pattern_ops)`)
+
+	// Add @handles (unique ignoring case) and the profile link version, bumped to revoke shared links
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS handle TEXT`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS handle_changed_at TIMESTAMP`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_link_version INTEGER NOT NULL DEFAULT 0`)
+	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_handle ON users(LOWER(handle))`)
+
+	// Add email verification state (pending_email awaits confirmation before replacing email)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email TEXT`)
+
+	// Create OpenID Connect identities linked to accounts, and pending sign-in attempts
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_identities (
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_login_at TIMESTAMP,
+			PRIMARY KEY (issuer, subject)
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create user_identities table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id)`)
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS oidc_login_states (
+			state TEXT PRIMARY KEY,
+			provider TEXT NOT NULL,
+			code_verifier TEXT NOT NULL,
+			nonce TEXT NOT NULL,
+			device_name TEXT,
+			platform TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create oidc_login_states table: %v", err)
+		return err
+	}
+
+	// Create linked (companion) devices, paired from the primary device with one-time tokens
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS devices (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			device_name TEXT NOT NULL,
+			platform TEXT NOT NULL,
+			identity_key TEXT NOT NULL,
+			last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create devices table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_devices_user ON devices(user_id)`)
+	db.Exec(`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_id TEXT`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_sessions_device ON sessions(device_id) WHERE device_id IS NOT NULL`)
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS device_pairing_tokens (
+			token_hash TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create device_pairing_tokens table: %v", err)
+		return err
+	}
+
+	// Add operator roles and suspension details (suspended_until NULL means indefinite)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user'`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS suspension_reason TEXT`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_until TIMESTAMP`)
+
+	// Create the audit log of operator actions
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS admin_audit_log (
+			id TEXT PRIMARY KEY,
+			admin_id TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target_user_id TEXT,
+			target_id TEXT,
+			reason TEXT,
+			details JSONB,
+			ip_address TEXT,
+			user_agent TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create admin_audit_log table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_admin_audit_log_created ON admin_audit_log(created_at DESC)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_admin_audit_log_target ON admin_audit_log(target_user_id, created_at DESC)`)
+	db.Exec(`ALTER TABLE user_reports ADD COLUMN IF NOT EXISTS resolved_by TEXT`)
+	db.Exec(`ALTER TABLE user_reports ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMP`)
+
+	// Create the security event log (user_id is NULL for attempts on unknown accounts)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS security_events (
+			id TEXT PRIMARY KEY,
+			user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+			event_type TEXT NOT NULL,
+			actor_id TEXT,
+			ip_address TEXT,
+			user_agent TEXT,
+			details JSONB,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create security_events table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_security_events_user ON security_events(user_id, created_at DESC)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_security_events_created ON security_events(created_at)`)
+
+	// Remember the devices each account logged in from, to alert on new ones
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS known_devices (
+			user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			fingerprint TEXT NOT NULL,
+			user_agent TEXT,
+			ip_address TEXT,
+			network TEXT,
+			first_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, fingerprint)
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create known_devices table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_known_devices_last_seen ON known_devices(last_seen)`)
+	// Set when the owner reports a login they did not recognise
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE`)
+
+	// Create invite codes; batch_id is set for codes minted by an operator
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS invite_codes (
+			code TEXT PRIMARY KEY,
+			created_by TEXT REFERENCES users(id) ON DELETE SET NULL,
+			batch_id TEXT,
+			max_uses INTEGER NOT NULL DEFAULT 1,
+			uses INTEGER NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+			note TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create invite_codes table: %v", err)
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_invite_codes_created_by ON invite_codes(created_by)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_invite_codes_batch ON invite_codes(batch_id)`)
+	// Record who invited whom
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS invited_by TEXT REFERENCES users(id) ON DELETE SET NULL`)
+	db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS invite_code TEXT`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_users_invited_by ON users(invited_by)`)
+
+	// Verified addresses sign in regardless of case, so they must be unique
+	// regardless of case. Fails on existing duplicates, which sign-in refuses.
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_verified_email_lower ON users (LOWER(email)) WHERE email_verified = TRUE`); err != nil {
+		log.Printf("Warning: verified email addresses differing only in case exist: %v", err)
+	}
+
 	log.Println("Database migrations completed successfully")
 	return nil
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/snaptalker/backend/pkg/oidc"
+)
+
+// loadOIDCProviders parses OIDC_PROVIDERS, a JSON array of provider
+// configurations. Only https issuers are accepted in production.
+func loadOIDCProviders(config Config) ([]*oidc.Provider, error) {
+	if config.OIDCProviders == "" {
+		return nil, nil
+	}
+
+	var configs []oidc.ProviderConfig
+	if err := json.Unmarshal([]byte(config.OIDCProviders), &configs); err != nil {
+		return nil, fmt.Errorf("OIDC_PROVIDERS: %w", err)
+	}
+
+	var providers []*oidc.Provider
+	seen := make(map[string]bool, len(configs))
+	for _, providerConfig := range configs {
+		if seen[providerConfig.Name] {
+			return nil, fmt.Errorf("OIDC_PROVIDERS: duplicate provider %q", providerConfig.Name)
+		}
+		seen[providerConfig.Name] = true
+		if config.Environment == "production" && !strings.HasPrefix(providerConfig.Issuer, "https://") {
+			return nil, fmt.Errorf("OIDC_PROVIDERS: issuer of %q must use https", providerConfig.Name)
+		}
+
+		provider, err := oidc.NewProvider(providerConfig, nil)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+		log.Printf("Single sign-on enabled with %s (%s)", providerConfig.Name, providerConfig.Issuer)
+	}
+	return providers, nil
+}
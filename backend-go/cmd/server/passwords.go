@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/snaptalker/backend/internal/auth"
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+// loadPasswordHashing builds the password hasher from the PASSWORD_ARGON2_*
+// settings and the password policy, including the breached password list
+// from BREACHED_PASSWORDS_FILE when one is configured
+func loadPasswordHashing(config Config) (*crypto.PasswordHasher, *crypto.PasswordPolicy, error) {
+	hasher, err := crypto.NewPasswordHasher(config.Argon2)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	policy := &crypto.PasswordPolicy{MinLength: config.PasswordMinLength, MaxLength: auth.MaxPasswordLength}
+	if policy.MinLength < auth.DefaultMinPasswordLength {
+		return nil, nil, fmt.Errorf("PASSWORD_MIN_LENGTH must be at least %d", auth.DefaultMinPasswordLength)
+	}
+	if config.BreachedPasswordsFile == "" {
+		log.Println("BREACHED_PASSWORDS_FILE not configured, new passwords are not checked against breached passwords")
+		return hasher, policy, nil
+	}
+
+	file, err := os.Open(config.BreachedPasswordsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	if err := policy.LoadBreachedPasswords(file); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", config.BreachedPasswordsFile, err)
+	}
+	log.Printf("Loaded %d breached password hashes", policy.BreachedCount())
+	return hasher, policy, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/lib/pq"
+	"github.com/snaptalker/backend/pkg/storage"
+)
+
+// set-role grants or revokes the admin role. The new role is carried in
+// access tokens issued after the change; the admin API re-checks it on
+// every request.
+func main() {
+	userID := flag.String("id", "", "user ID")
+	phone := flag.String("phone", "", "phone number of the user")
+	role := flag.String("role", "admin", "role to set: admin or user")
+	flag.Parse()
+
+	if (*userID == "") == (*phone == "") {
+		log.Fatal("exactly one of -id or -phone is required")
+	}
+	if *role != "admin" && *role != "user" {
+		log.Fatalf("unknown role %q", *role)
+	}
+
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		log.Fatal("DATABASE_URL is not set")
+	}
+
+	db, err := storage.NewPostgresDB(dbURL)
+	if err != nil {
+		log.Fatalf("failed to connect to db: %v", err)
+	}
+	defer db.Close()
+
+	var id, username string
+	err = db.QueryRow(
+		`UPDATE users SET role = $1 WHERE id = $2 OR phone = $3 RETURNING id, username`,
+		*role, *userID, *phone,
+	).Scan(&id, &username)
+	if err != nil {
+		log.Fatalf("failed to set role: %v", err)
+	}
+
+	fmt.Printf("%s (%s) now has role %s\n", username, id, *role)
+}
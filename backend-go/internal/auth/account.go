@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Account states stored in users.status
+const (
+	AccountPending   = "pending"   // registered, phone not yet verified
+	AccountActive    = "active"    // verified and allowed to use the service
+	AccountSuspended = "suspended" // blocked by an operator, possibly until suspended_until
+	AccountBanned    = "banned"    // permanently blocked by an operator
+	AccountDeleted   = "deleted"   // scheduled for or undergoing deletion
+)
+
+// Roles stored in users.role and carried in the access token's role claim
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// otpResendCooldown is the minimum time between two OTPs for the same phone
+const otpResendCooldown = time.Minute
+
+// ResendOTP sends a new registration OTP to a pending account
+func (s *Service) ResendOTP(c *gin.Context) {
+	var req struct {
+		Phone string `json:"phone" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Enforce cooldown since the last code for this phone
+	var lastSent sql.NullTime
+	query := `SELECT MAX(created_at) FROM otp_codes WHERE identifier = $1 AND purpose = $2`
+	s.db.QueryRow(query, req.Phone, string(OTPPurposeRegister)).Scan(&lastSent)
+	if lastSent.Valid {
+		if wait := otpResendCooldown - time.Since(lastSent.Time); wait > 0 {
+			seconds := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":      "please wait before requesting another OTP",
+				"retryAfter": seconds,
+			})
+			return
+		}
+	}
+
+	// Only pending registrations and pending re-registrations get a new code;
+	// the response is the same either way so phone numbers cannot be probed
+	var email string
+	query = `
+		SELECT u.email FROM users u
+		WHERE u.phone = $1
+		AND (u.status = $2 OR EXISTS (SELECT 1 FROM pending_reregistrations p WHERE p.phone = u.phone))
+	`
+	err := s.db.QueryRow(query, req.Phone, AccountPending).Scan(&email)
+	if err == nil {
+		recipient := OTPRecipient{Phone: req.Phone, Email: email}
+		if err := s.issueOTP(c.Request.Context(), req.Phone, recipient, OTPPurposeRegister); err != nil {
+			log.Printf("Failed to resend OTP to %s: %v", req.Phone, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If a verification is pending for this number, a new OTP has been sent"})
+}
+
+// activateAccount marks a pending account as active after phone verification
+func (s *Service) activateAccount(phone string) error {
+	query := `UPDATE users SET status = $1, updated_at = $2 WHERE phone = $3 AND status = $4`
+	_, err := s.db.Exec(query, AccountActive, time.Now(), phone, AccountPending)
+	return err
+}
+
+// accountStatusError maps a non-active account status to the HTTP status and
+// message returned to the client
+func accountStatusError(status string) (int, string) {
+	switch status {
+	case AccountPending:
+		return http.StatusForbidden, "account not verified"
+	case AccountSuspended:
+		return http.StatusForbidden, "account suspended"
+	case AccountBanned:
+		return http.StatusForbidden, "account banned"
+	case AccountDeleted:
+		return http.StatusForbidden, "account scheduled for deletion"
+	default:
+		return http.StatusUnauthorized, "account not found"
+	}
+}
+
+// StartPendingAccountPurger periodically deletes registrations that were
+// never verified within maxAge, along with expired OTP codes
+func (s *Service) StartPendingAccountPurger(ctx context.Context, interval, maxAge time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.purgePendingAccounts(maxAge)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Service) purgePendingAccounts(maxAge time.Duration) {
+	cutoff := time.Now().Add(-maxAge)
+
+	// Give the invites of abandoned registrations back
+	query := `
+		UPDATE invite_codes i SET uses = GREATEST(i.uses - (
+			SELECT COUNT(*) FROM users u WHERE u.invite_code = i.code AND u.status = $1 AND u.created_at < $2
+		), 0)
+		WHERE i.code IN (SELECT invite_code FROM users WHERE status = $1 AND created_at < $2)
+	`
+	if _, err := s.db.Exec(query, AccountPending, cutoff); err != nil {
+		log.Printf("Failed to release invites of pending accounts: %v", err)
+	}
+
+	result, err := s.db.Exec(`DELETE FROM users WHERE status = $1 AND created_at < $2`, AccountPending, cutoff)
+	if err != nil {
+		log.Printf("Failed to purge pending accounts: %v", err)
+		return
+	}
+	if count, _ := result.RowsAffected(); count > 0 {
+		log.Printf("Purged %d unverified registrations", count)
+	}
+
+	s.db.Exec(`DELETE FROM otp_codes WHERE expires_at < $1`, time.Now().Add(-24*time.Hour))
+	s.db.Exec(`DELETE FROM pending_reregistrations WHERE created_at < $1`, time.Now().Add(-24*time.Hour))
+	s.db.Exec(`DELETE FROM pending_phone_changes WHERE created_at < $1`, time.Now().Add(-24*time.Hour))
+	s.purgeKnownDevices()
+
+	query = `
+		UPDATE users SET status = $1, suspension_reason = NULL, suspended_until = NULL, updated_at = $2
+		WHERE status = $3 AND suspended_until <= $2
+	`
+	s.db.Exec(query, AccountActive, time.Now(), AccountSuspended)
+}
+
+// liftExpiredSuspension reactivates a suspended account whose suspension has
+// run out and returns the account's current status
+func (s *Service) liftExpiredSuspension(userID, status string) string {
+	if status != AccountSuspended {
+		return status
+	}
+	query := `
+		UPDATE users SET status = $1, suspension_reason = NULL, suspended_until = NULL, updated_at = $2
+		WHERE id = $3 AND status = $4 AND suspended_until <= $2
+	`
+	result, err := s.db.Exec(query, AccountActive, time.Now(), userID, AccountSuspended)
+	if err != nil {
+		return status
+	}
+	if rows, _ := result.RowsAffected(); rows == 1 {
+		return AccountActive
+	}
+	return status
+}
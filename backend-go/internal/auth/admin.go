@@ -0,0 +1,585 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/snaptalker/backend/internal/security"
+)
+
+// Abuse report states an operator can move a report between
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// maxAdminPageSize caps list endpoints of the admin API
+const maxAdminPageSize = 200
+
+// AdminUser is an account as shown to operators
+type AdminUser struct {
+	ID               string     `json:"id"`
+	Username         string     `json:"username"`
+	Phone            string     `json:"phone"`
+	Email            string     `json:"email"`
+	EmailVerified    bool       `json:"emailVerified"`
+	Handle           string     `json:"handle,omitempty"`
+	Status           string     `json:"status"`
+	Role             string     `json:"role"`
+	SuspensionReason string     `json:"suspensionReason,omitempty"`
+	SuspendedUntil   *time.Time `json:"suspendedUntil,omitempty"`
+	TwoFactorEnabled bool       `json:"twoFactorEnabled"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	LastSeen         *time.Time `json:"lastSeen,omitempty"`
+	InvitedBy        string     `json:"invitedBy,omitempty"`
+	ActiveSessions   int        `json:"activeSessions"`
+	OpenReports      int        `json:"openReports"`
+}
+
+// AbuseReport is a report filed when a user blocked and reported another
+type AbuseReport struct {
+	ID               string          `json:"id"`
+	ReporterID       string          `json:"reporterId"`
+	ReporterUsername string          `json:"reporterUsername"`
+	ReportedUserID   string          `json:"reportedUserId"`
+	ReportedUsername string          `json:"reportedUsername"`
+	Reason           string          `json:"reason"`
+	Messages         json.RawMessage `json:"messages,omitempty"`
+	Status           string          `json:"status"`
+	ResolvedBy       string          `json:"resolvedBy,omitempty"`
+	ResolvedAt       *time.Time      `json:"resolvedAt,omitempty"`
+	CreatedAt        time.Time       `json:"createdAt"`
+}
+
+// AuditEntry is one recorded operator action
+type AuditEntry struct {
+	ID           string          `json:"id"`
+	AdminID      string          `json:"adminId"`
+	Action       string          `json:"action"`
+	TargetUserID string          `json:"targetUserId,omitempty"`
+	TargetID     string          `json:"targetId,omitempty"`
+	Reason       string          `json:"reason,omitempty"`
+	Details      json.RawMessage `json:"details,omitempty"`
+	IPAddress    string          `json:"ipAddress"`
+	UserAgent    string          `json:"userAgent"`
+	CreatedAt    time.Time       `json:"createdAt"`
+}
+
+// ModerationRequest carries the reason every operator action must give.
+// Until optionally ends a suspension automatically.
+type ModerationRequest struct {
+	Reason string     `json:"reason" binding:"required,max=500"`
+	Until  *time.Time `json:"until"`
+}
+
+// UpdateReportRequest moves an abuse report to another state
+type UpdateReportRequest struct {
+	Status string `json:"status" binding:"required,oneof=open resolved dismissed"`
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// OnAccountDisabled registers a callback invoked when an operator suspends
+// or bans an account, so realtime services can drop all its connections
+func (s *Service) OnAccountDisabled(fn func(userID string)) {
+	s.accountDisabledHooks = append(s.accountDisabledHooks, fn)
+}
+
+// AdminMiddleware restricts a route group to operators. It runs after
+// AuthMiddleware and re-checks the role claim against the database, so a
+// revoked role takes effect before the access token expires.
+func (s *Service) AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var role string
+		if c.GetString("role") == RoleAdmin {
+			s.db.QueryRow(`SELECT role FROM users WHERE id = $1`, c.GetString("userId")).Scan(&role)
+		}
+		if role != RoleAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin role required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+const adminUserQuery = `
+	SELECT u.id, u.username, u.phone, u.email, COALESCE(u.email_verified, FALSE), u.handle, u.status, u.role,
+		u.suspension_reason, u.suspended_until, COALESCE(u.totp_enabled, FALSE), u.created_at, u.last_seen, u.invited_by,
+		(SELECT COUNT(*) FROM sessions s WHERE s.user_id = u.id AND s.revoked_at IS NULL AND s.expires_at > NOW()),
+		(SELECT COUNT(*) FROM user_reports r WHERE r.reported_user_id = u.id AND r.status = 'open')
+	FROM users u
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAdminUser(row rowScanner) (AdminUser, error) {
+	var user AdminUser
+	var handle, suspensionReason, invitedBy sql.NullString
+	var suspendedUntil, lastSeen sql.NullTime
+	err := row.Scan(
+		&user.ID, &user.Username, &user.Phone, &user.Email, &user.EmailVerified, &handle, &user.Status, &user.Role,
+		&suspensionReason, &suspendedUntil, &user.TwoFactorEnabled, &user.CreatedAt, &lastSeen, &invitedBy,
+		&user.ActiveSessions, &user.OpenReports,
+	)
+	if err != nil {
+		return user, err
+	}
+	user.Handle = handle.String
+	user.SuspensionReason = suspensionReason.String
+	user.InvitedBy = invitedBy.String
+	if suspendedUntil.Valid {
+		user.SuspendedUntil = &suspendedUntil.Time
+	}
+	if lastSeen.Valid {
+		user.LastSeen = &lastSeen.Time
+	}
+	return user, nil
+}
+
+// AdminSearchUsers looks accounts up by ID, phone number, email address,
+// handle or username prefix
+func (s *Service) AdminSearchUsers(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "search query is required"})
+		return
+	}
+	limit := adminPageSize(c)
+
+	sqlQuery := adminUserQuery + `
+		WHERE u.id = $1 OR u.phone = $1 OR LOWER(u.email) = LOWER($1) OR LOWER(u.handle) = LOWER(TRIM(LEADING '@' FROM $1))
+			OR LOWER(u.username) LIKE LOWER($2)
+		ORDER BY u.created_at DESC
+		LIMIT $3
+	`
+	rows, err := s.db.Query(sqlQuery, query, likeEscaper.Replace(query)+"%", limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search users"})
+		return
+	}
+	defer rows.Close()
+
+	users := []AdminUser{}
+	for rows.Next() {
+		user, err := scanAdminUser(rows)
+		if err != nil {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// AdminGetUser returns an account with its moderation history
+func (s *Service) AdminGetUser(c *gin.Context) {
+	userID := c.Param("userId")
+
+	user, err := scanAdminUser(s.db.QueryRow(adminUserQuery+` WHERE u.id = $1`, userID))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+		return
+	}
+
+	history, err := s.auditEntries(userID, 20)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user, "history": history})
+}
+
+// AdminSuspendUser blocks an account until an operator lifts the suspension
+// or the optional end time passes, and disconnects all its devices
+func (s *Service) AdminSuspendUser(c *gin.Context) {
+	var req ModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Until != nil && !req.Until.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "suspension end must be in the future"})
+		return
+	}
+	userID := c.Param("userId")
+	status, ok := s.moderationTarget(c, userID)
+	if !ok {
+		return
+	}
+	if status != AccountActive && status != AccountSuspended {
+		c.JSON(http.StatusConflict, gin.H{"error": "only active accounts can be suspended", "status": status})
+		return
+	}
+
+	query := `UPDATE users SET status = $1, suspension_reason = $2, suspended_until = $3, updated_at = $4 WHERE id = $5`
+	if _, err := s.db.Exec(query, AccountSuspended, req.Reason, req.Until, time.Now(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to suspend user"})
+		return
+	}
+	s.disableAccount(userID)
+
+	details := gin.H{"previousStatus": status}
+	if req.Until != nil {
+		details["until"] = req.Until
+	}
+	s.auditAdminAction(c, "suspend", userID, "", req.Reason, details)
+
+	c.JSON(http.StatusOK, gin.H{"message": "user suspended", "userId": userID, "suspendedUntil": req.Until})
+}
+
+// AdminBanUser permanently blocks an account and revokes all its sessions
+func (s *Service) AdminBanUser(c *gin.Context) {
+	var req ModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.Param("userId")
+	status, ok := s.moderationTarget(c, userID)
+	if !ok {
+		return
+	}
+	if status == AccountBanned || status == AccountDeleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "account cannot be banned", "status": status})
+		return
+	}
+
+	query := `UPDATE users SET status = $1, suspension_reason = $2, suspended_until = NULL, updated_at = $3 WHERE id = $4`
+	if _, err := s.db.Exec(query, AccountBanned, req.Reason, time.Now(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to ban user"})
+		return
+	}
+	revoked, _ := s.revokeAllSessions(c, userID, "", "banned")
+	s.disableAccount(userID)
+	s.auditAdminAction(c, "ban", userID, "", req.Reason, gin.H{"previousStatus": status, "revokedSessions": revoked})
+
+	c.JSON(http.StatusOK, gin.H{"message": "user banned", "userId": userID})
+}
+
+// AdminUnsuspendUser reactivates a suspended or banned account
+func (s *Service) AdminUnsuspendUser(c *gin.Context) {
+	var req ModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.Param("userId")
+	status, ok := s.moderationTarget(c, userID)
+	if !ok {
+		return
+	}
+	if status != AccountSuspended && status != AccountBanned {
+		c.JSON(http.StatusConflict, gin.H{"error": "account is not suspended", "status": status})
+		return
+	}
+
+	query := `
+		UPDATE users SET status = $1, suspension_reason = NULL, suspended_until = NULL, updated_at = $2
+		WHERE id = $3 AND status = $4
+	`
+	if _, err := s.db.Exec(query, AccountActive, time.Now(), userID, status); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reactivate user"})
+		return
+	}
+	s.auditAdminAction(c, "unsuspend", userID, "", req.Reason, gin.H{"previousStatus": status})
+
+	c.JSON(http.StatusOK, gin.H{"message": "user reactivated", "userId": userID})
+}
+
+// AdminLogoutUser revokes every session of an account
+func (s *Service) AdminLogoutUser(c *gin.Context) {
+	var req ModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.Param("userId")
+	if _, ok := s.moderationTarget(c, userID); !ok {
+		return
+	}
+
+	count, err := s.revokeAllSessions(c, userID, "", "admin_logout")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out user"})
+		return
+	}
+	s.auditAdminAction(c, "force_logout", userID, "", req.Reason, gin.H{"revokedSessions": count})
+
+	c.JSON(http.StatusOK, gin.H{"message": "user logged out", "userId": userID, "revokedSessions": count})
+}
+
+// AdminResetTwoFactor turns off TOTP, recovery codes and the registration
+// lock of an account that lost access to its second factor
+func (s *Service) AdminResetTwoFactor(c *gin.Context) {
+	var req ModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.Param("userId")
+	if _, ok := s.moderationTarget(c, userID); !ok {
+		return
+	}
+
+	query := `
+		UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0, registration_lock_hash = NULL
+		WHERE id = $1
+	`
+	if _, err := s.db.Exec(query, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset two-factor authentication"})
+		return
+	}
+	s.db.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	s.auditAdminAction(c, "reset_2fa", userID, "", req.Reason, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication reset", "userId": userID})
+}
+
+// AdminGetReports lists abuse reports, open ones by default
+func (s *Service) AdminGetReports(c *gin.Context) {
+	status := c.DefaultQuery("status", ReportOpen)
+	limit := adminPageSize(c)
+
+	query := `
+		SELECT r.id, r.reporter_id, reporter.username, r.reported_user_id, reported.username,
+			COALESCE(r.reason, ''), r.status, r.resolved_by, r.resolved_at, r.created_at
+		FROM user_reports r
+		JOIN users reporter ON reporter.id = r.reporter_id
+		JOIN users reported ON reported.id = r.reported_user_id
+		WHERE r.status = $1 AND ($2 = '' OR r.reported_user_id = $2)
+		ORDER BY r.created_at DESC
+		LIMIT $3
+	`
+	rows, err := s.db.Query(query, status, c.Query("userId"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get reports"})
+		return
+	}
+	defer rows.Close()
+
+	reports := []AbuseReport{}
+	for rows.Next() {
+		report, err := scanAbuseReport(rows, false)
+		if err != nil {
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reports": reports})
+}
+
+// AdminGetReport returns an abuse report with the reported messages
+func (s *Service) AdminGetReport(c *gin.Context) {
+	reportID := c.Param("reportId")
+
+	query := `
+		SELECT r.id, r.reporter_id, reporter.username, r.reported_user_id, reported.username,
+			COALESCE(r.reason, ''), r.status, r.resolved_by, r.resolved_at, r.created_at, r.messages
+		FROM user_reports r
+		JOIN users reporter ON reporter.id = r.reporter_id
+		JOIN users reported ON reported.id = r.reported_user_id
+		WHERE r.id = $1
+	`
+	report, err := scanAbuseReport(s.db.QueryRow(query, reportID), true)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "report not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get report"})
+		return
+	}
+	s.auditAdminAction(c, "view_report", report.ReportedUserID, report.ID, "", nil)
+
+	c.JSON(http.StatusOK, report)
+}
+
+// AdminUpdateReport resolves, dismisses or reopens an abuse report
+func (s *Service) AdminUpdateReport(c *gin.Context) {
+	var req UpdateReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reportID := c.Param("reportId")
+	adminID := c.GetString("userId")
+
+	var resolvedBy interface{}
+	var resolvedAt interface{}
+	if req.Status != ReportOpen {
+		resolvedBy, resolvedAt = adminID, time.Now()
+	}
+	var reportedUserID string
+	query := `UPDATE user_reports SET status = $1, resolved_by = $2, resolved_at = $3 WHERE id = $4 RETURNING reported_user_id`
+	err := s.db.QueryRow(query, req.Status, resolvedBy, resolvedAt, reportID).Scan(&reportedUserID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "report not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update report"})
+		return
+	}
+	s.auditAdminAction(c, "report_"+req.Status, reportedUserID, reportID, req.Reason, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "report updated", "reportId": reportID, "status": req.Status})
+}
+
+// AdminGetAuditLog lists recent operator actions, optionally for one user
+func (s *Service) AdminGetAuditLog(c *gin.Context) {
+	entries, err := s.auditEntries(c.Query("userId"), adminPageSize(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// moderationTarget loads the status of the account an operator acts on.
+// Operators cannot act on themselves or on other operators.
+func (s *Service) moderationTarget(c *gin.Context, userID string) (string, bool) {
+	if userID == c.GetString("userId") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot moderate your own account"})
+		return "", false
+	}
+	var status, role string
+	err := s.db.QueryRow(`SELECT status, role FROM users WHERE id = $1`, userID).Scan(&status, &role)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return "", false
+	}
+	if role == RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot moderate another admin"})
+		return "", false
+	}
+	return status, true
+}
+
+// disableAccount disconnects every realtime connection of a blocked account
+func (s *Service) disableAccount(userID string) {
+	for _, hook := range s.accountDisabledHooks {
+		hook(userID)
+	}
+}
+
+// auditAdminAction records an operator action with the operator's IP
+// address and user agent
+func (s *Service) auditAdminAction(c *gin.Context, action, targetUserID, targetID, reason string, details gin.H) {
+	var detailsJSON interface{}
+	if details != nil {
+		encoded, _ := json.Marshal(details)
+		detailsJSON = string(encoded)
+	}
+	query := `
+		INSERT INTO admin_audit_log (id, admin_id, action, target_user_id, target_id, reason, details, ip_address, user_agent, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9, $10)
+	`
+	_, err := s.db.Exec(query, uuid.New().String(), c.GetString("userId"), action, targetUserID, targetID, reason,
+		detailsJSON, c.ClientIP(), c.Request.UserAgent(), time.Now())
+	if err != nil {
+		log.Printf("Failed to audit admin action %s by %s: %v", action, c.GetString("userId"), err)
+	}
+
+	// Actions on the account show up in the user's security events, without
+	// the operator's address; report handling stays with the operators
+	if targetUserID != "" && targetID == "" {
+		s.config.SecurityLog.Record(security.Event{
+			UserID:    targetUserID,
+			Type:      security.AdminAction,
+			ActorID:   c.GetString("userId"),
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			Details:   map[string]interface{}{"action": action},
+		})
+	}
+}
+
+func (s *Service) auditEntries(targetUserID string, limit int) ([]AuditEntry, error) {
+	query := `
+		SELECT id, admin_id, action, target_user_id, target_id, reason, details, ip_address, user_agent, created_at
+		FROM admin_audit_log
+		WHERE $1 = '' OR target_user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+	rows, err := s.db.Query(query, targetUserID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var targetUser, target, reason, ipAddress, userAgent sql.NullString
+		var details []byte
+		if err := rows.Scan(&entry.ID, &entry.AdminID, &entry.Action, &targetUser, &target, &reason, &details, &ipAddress, &userAgent, &entry.CreatedAt); err != nil {
+			continue
+		}
+		entry.TargetUserID = targetUser.String
+		entry.TargetID = target.String
+		entry.Reason = reason.String
+		entry.Details = details
+		entry.IPAddress = ipAddress.String
+		entry.UserAgent = userAgent.String
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func scanAbuseReport(row rowScanner, withMessages bool) (AbuseReport, error) {
+	var report AbuseReport
+	var resolvedBy sql.NullString
+	var resolvedAt sql.NullTime
+	dest := []interface{}{
+		&report.ID, &report.ReporterID, &report.ReporterUsername, &report.ReportedUserID, &report.ReportedUsername,
+		&report.Reason, &report.Status, &resolvedBy, &resolvedAt, &report.CreatedAt,
+	}
+	var messages []byte
+	if withMessages {
+		dest = append(dest, &messages)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return report, err
+	}
+	report.ResolvedBy = resolvedBy.String
+	if resolvedAt.Valid {
+		report.ResolvedAt = &resolvedAt.Time
+	}
+	if withMessages {
+		report.Messages = messages
+	}
+	return report, nil
+}
+
+// adminPageSize reads the limit query parameter of admin list endpoints
+func adminPageSize(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 {
+		return 50
+	}
+	if limit > maxAdminPageSize {
+		return maxAdminPageSize
+	}
+	return limit
+}
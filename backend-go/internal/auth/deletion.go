@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeleteAccountRequest confirms an account deletion
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+	// Code is a two-step verification code, required when TOTP is enabled
+	Code string `json:"code"`
+}
+
+// OnContactEvent registers a callback that delivers an event to everyone who
+// has a conversation with userID, e.g. over the messaging WebSocket. The event
+// is built per recipient so privacy settings can be applied; a nil event
+// skips that recipient.
+func (s *Service) OnContactEvent(fn func(userID string, event func(recipientID string) map[string]interface{})) {
+	s.contactEventHooks = append(s.contactEventHooks, fn)
+}
+
+// notifyContacts delivers the same event to all of userID's contacts
+func (s *Service) notifyContacts(userID string, event map[string]interface{}) {
+	for _, hook := range s.contactEventHooks {
+		hook(userID, func(string) map[string]interface{} { return event })
+	}
+}
+
+// DeleteAccount schedules the current user's account for deletion. The account
+// is disabled at once and purged after the configured grace period.
+func (s *Service) DeleteAccount(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limits := []limitCheck{{reauthPolicy, userID}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	if !s.checkPassword(userID, req.Password) {
+		s.recordFailure(c, limits...)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+		return
+	}
+
+	var totpEnabled bool
+	s.db.QueryRow(`SELECT totp_enabled FROM users WHERE id = $1`, userID).Scan(&totpEnabled)
+	if totpEnabled {
+		if req.Code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-step verification code required"})
+			return
+		}
+		if !s.verifyTOTP(userID, req.Code) {
+			s.recordFailure(c, limits...)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid verification code"})
+			return
+		}
+	}
+	s.recordSuccess(c, limits...)
+
+	if s.config.DeletionGracePeriod <= 0 {
+		if err := s.purgeAccount(c.Request.Context(), userID); err != nil {
+			log.Printf("Failed to purge account %s: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "account deleted"})
+		return
+	}
+
+	scheduledAt := time.Now().Add(s.config.DeletionGracePeriod)
+	query := `UPDATE users SET status = $1, deletion_scheduled_at = $2, updated_at = $3 WHERE id = $4 AND status = $5`
+	result, err := s.db.Exec(query, AccountDeleted, scheduledAt, time.Now(), userID, AccountActive)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+		return
+	}
+	// The account stopped being active since the request was authenticated,
+	// e.g. a concurrent deletion or a suspension
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "account is not active"})
+		return
+	}
+
+	if _, err := s.revokeAllSessions(c, userID, "", "account_deleted"); err != nil {
+		log.Printf("Failed to revoke sessions of deleted account %s: %v", userID, err)
+	}
+	if s.redis != nil {
+		s.redis.Delete(c.Request.Context(), fmt.Sprintf("online:%s", userID))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "account scheduled for deletion",
+		"deletionAt":  scheduledAt,
+		"gracePeriod": s.config.DeletionGracePeriod.String(),
+	})
+}
+
+// CancelAccountDeletion restores an account that is still within its
+// deletion grace period
+func (s *Service) CancelAccountDeletion(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limits := []limitCheck{{loginPhonePolicy, req.Phone}, {loginIPPolicy, c.ClientIP()}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	var userID, passwordHash string
+	query := `
+		SELECT id, password_hash FROM users
+		WHERE phone = $1 AND status = $2 AND deletion_scheduled_at > NOW()
+	`
+	err := s.db.QueryRow(query, req.Phone, AccountDeleted).Scan(&userID, &passwordHash)
+	if err != nil || !s.passwordMatches(userID, req.Password, passwordHash) {
+		s.recordFailure(c, limits...)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+	s.recordSuccess(c, limits[0])
+
+	query = `UPDATE users SET status = $1, deletion_scheduled_at = NULL, updated_at = $2 WHERE id = $3 AND status = $4`
+	if _, err := s.db.Exec(query, AccountActive, time.Now(), userID, AccountDeleted); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account deletion cancelled, please log in again"})
+}
+
+// StartAccountDeletionWorker periodically purges accounts whose deletion
+// grace period has elapsed, along with expired data exports
+func (s *Service) StartAccountDeletionWorker(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.purgeDeletedAccounts(ctx)
+			s.purgeExpiredExports(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Service) purgeDeletedAccounts(ctx context.Context) {
+	query := `SELECT id FROM users WHERE status = $1 AND deletion_scheduled_at <= NOW()`
+	rows, err := s.db.Query(query, AccountDeleted)
+	if err != nil {
+		log.Printf("Failed to list accounts due for deletion: %v", err)
+		return
+	}
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err == nil {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		if err := s.purgeAccount(ctx, userID); err != nil {
+			log.Printf("Failed to purge account %s: %v", userID, err)
+			continue
+		}
+		log.Printf("Purged deleted account %s", userID)
+	}
+}
+
+// purgeAccount permanently removes a user and everything they own: messages
+// and reactions, call history, pre-keys, media, avatars and data exports, and
+// cached keys and presence
+func (s *Service) purgeAccount(ctx context.Context, userID string) error {
+	var phone string
+	err := s.db.QueryRow(`SELECT phone FROM users WHERE id = $1`, userID).Scan(&phone)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Contacts are told before the conversations they are derived from go away
+	s.notifyContacts(userID, map[string]interface{}{
+		"type":   "account_deleted",
+		"userId": userID,
+	})
+	if _, err := s.revokeAllSessions(nil, userID, "", "account_deleted"); err != nil {
+		log.Printf("Failed to revoke sessions of %s: %v", userID, err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []struct {
+		query string
+		arg   string
+	}{
+		{`DELETE FROM message_reactions WHERE user_id = $1 OR message_id IN (SELECT id FROM messages WHERE sender_id = $1 OR recipient_id = $1)`, userID},
+		{`DELETE FROM messages WHERE sender_id = $1 OR recipient_id = $1`, userID},
+		{`DELETE FROM call_logs WHERE caller_id = $1 OR callee_id = $1`, userID},
+		{`DELETE FROM pre_keys WHERE user_id = $1`, userID},
+		{`DELETE FROM otp_codes WHERE identifier = $1`, phone},
+		{`DELETE FROM users WHERE id = $1`, userID},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.arg); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Pre-keys uploaded through the signal service live in a separate table
+	s.db.Exec(`DELETE FROM prekeys WHERE user_id = $1`, userID)
+
+	if s.minio != nil {
+		if err := s.minio.DeletePrefix(ctx, fmt.Sprintf("media/%s/", userID)); err != nil {
+			log.Printf("Failed to delete media of %s: %v", userID, err)
+		}
+		if err := s.minio.DeletePrefix(ctx, fmt.Sprintf("avatars/%s/", userID)); err != nil {
+			log.Printf("Failed to delete avatars of %s: %v", userID, err)
+		}
+		if err := s.minio.DeletePrefix(ctx, fmt.Sprintf("exports/%s/", userID)); err != nil {
+			log.Printf("Failed to delete data exports of %s: %v", userID, err)
+		}
+	}
+
+	if s.redis != nil {
+		s.redis.Delete(ctx, fmt.Sprintf("keybundle:%s", userID))
+		s.redis.Delete(ctx, fmt.Sprintf("online:%s", userID))
+		s.redis.DeletePattern(ctx, fmt.Sprintf("chat:%s:*", userID))
+		s.redis.DeletePattern(ctx, fmt.Sprintf("chat:*:%s", userID))
+	}
+	return nil
+}
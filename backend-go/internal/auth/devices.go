@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/snaptalker/backend/internal/security"
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+const (
+	// MaxLinkedDevices is how many companion devices an account may link in
+	// addition to its primary device
+	MaxLinkedDevices = 4
+	// devicePairingTTL is how long a pairing QR code can be scanned
+	devicePairingTTL = 2 * time.Minute
+	// deviceInactivityLimit unlinks companion devices that were not used
+	// for this long
+	deviceInactivityLimit = 30 * 24 * time.Hour
+)
+
+// Device is a companion device linked to an account
+type Device struct {
+	ID           string    `json:"id"`
+	DeviceName   string    `json:"deviceName"`
+	Platform     string    `json:"platform"`
+	IdentityKey  string    `json:"identityKey"`
+	LastActiveAt time.Time `json:"lastActiveAt"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Current      bool      `json:"current"`
+}
+
+// LinkDeviceRequest registers a companion device with a scanned pairing token
+type LinkDeviceRequest struct {
+	PairingToken string `json:"pairingToken" binding:"required"`
+	DeviceName   string `json:"deviceName" binding:"required,max=100"`
+	Platform     string `json:"platform" binding:"required,max=20"`
+	IdentityKey  string `json:"identityKey" binding:"required,max=1024"`
+}
+
+// CreateDevicePairing issues a one-time pairing token for the primary device
+// to show as a QR code. Only the primary device may link new devices.
+func (s *Service) CreateDevicePairing(c *gin.Context) {
+	userID := c.GetString("userId")
+	if c.GetString("deviceId") != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "devices can only be linked from the primary device"})
+		return
+	}
+
+	s.pruneDevices(c, userID)
+	var count int
+	s.db.QueryRow(`SELECT COUNT(*) FROM devices WHERE user_id = $1`, userID).Scan(&count)
+	if count >= MaxLinkedDevices {
+		c.JSON(http.StatusConflict, gin.H{"error": "linked device limit reached, unlink a device first", "maxDevices": MaxLinkedDevices})
+		return
+	}
+
+	token, err := crypto.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create pairing code"})
+		return
+	}
+
+	now := time.Now()
+	s.db.Exec(`DELETE FROM device_pairing_tokens WHERE user_id = $1 OR expires_at < $2`, userID, now)
+	query := `INSERT INTO device_pairing_tokens (token_hash, user_id, created_at, expires_at) VALUES ($1, $2, $3, $4)`
+	if _, err := s.db.Exec(query, crypto.HashString(token), userID, now, now.Add(devicePairingTTL)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create pairing code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pairingToken": token,
+		"qrPayload":    strings.TrimSuffix(s.config.PublicURL, "/") + "/link-device?token=" + url.QueryEscape(token),
+		"expiresIn":    int(devicePairingTTL.Seconds()),
+	})
+}
+
+// LinkDevice registers a companion device under the account that issued the
+// scanned pairing token and signs it in with its own session
+func (s *Service) LinkDevice(c *gin.Context) {
+	var req LinkDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limits := []limitCheck{{loginIPPolicy, c.ClientIP()}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to link device"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Pairing tokens are single-use
+	var userID string
+	query := `DELETE FROM device_pairing_tokens WHERE token_hash = $1 AND expires_at > $2 RETURNING user_id`
+	if err := tx.QueryRow(query, crypto.HashString(req.PairingToken), time.Now()).Scan(&userID); err != nil {
+		s.recordFailure(c, limits...)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired pairing code"})
+		return
+	}
+
+	// Lock the account so concurrent links cannot exceed the device limit
+	var status string
+	var resetRequired bool
+	query = `SELECT status, password_reset_required FROM users WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRow(query, userID).Scan(&status, &resetRequired); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired pairing code"})
+		return
+	}
+	if status != AccountActive {
+		code, message := accountStatusError(status)
+		c.JSON(code, gin.H{"error": message, "status": status})
+		return
+	}
+	if resetRequired {
+		c.JSON(http.StatusForbidden, gin.H{"error": "password reset required", "passwordResetRequired": true})
+		return
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM devices WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to link device"})
+		return
+	}
+	if count >= MaxLinkedDevices {
+		c.JSON(http.StatusConflict, gin.H{"error": "linked device limit reached", "maxDevices": MaxLinkedDevices})
+		return
+	}
+
+	now := time.Now()
+	device := Device{
+		ID:           uuid.New().String(),
+		DeviceName:   strings.TrimSpace(req.DeviceName),
+		Platform:     req.Platform,
+		IdentityKey:  req.IdentityKey,
+		LastActiveAt: now,
+		CreatedAt:    now,
+		Current:      true,
+	}
+	query = `
+		INSERT INTO devices (id, user_id, device_name, platform, identity_key, last_seen, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+	`
+	if _, err := tx.Exec(query, device.ID, userID, device.DeviceName, device.Platform, device.IdentityKey, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to link device"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to link device"})
+		return
+	}
+	s.recordSuccess(c, limits...)
+
+	token, refreshToken, _, err := s.issueDeviceTokens(c, userID, device.ID, device.DeviceName, device.Platform)
+	if err != nil {
+		s.db.Exec(`DELETE FROM devices WHERE id = $1`, device.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load profile"})
+		return
+	}
+
+	linked := device
+	linked.Current = false
+	s.notifyUser(userID, map[string]interface{}{
+		"type":   "device_linked",
+		"device": linked,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"deviceId":     device.ID,
+		"token":        token,
+		"refreshToken": refreshToken,
+		"user":         user,
+	})
+}
+
+// GetDevices lists the companion devices linked to the current account
+func (s *Service) GetDevices(c *gin.Context) {
+	userID := c.GetString("userId")
+	currentDeviceID := c.GetString("deviceId")
+
+	s.pruneDevices(c, userID)
+	query := `
+		SELECT id, device_name, platform, identity_key, last_seen, created_at
+		FROM devices
+		WHERE user_id = $1
+		ORDER BY last_seen DESC
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get devices"})
+		return
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		var device Device
+		if err := rows.Scan(&device.ID, &device.DeviceName, &device.Platform, &device.IdentityKey, &device.LastActiveAt, &device.CreatedAt); err != nil {
+			continue
+		}
+		device.Current = device.ID == currentDeviceID
+		devices = append(devices, device)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"devices":           devices,
+		"maxDevices":        MaxLinkedDevices,
+		"primary":           currentDeviceID == "",
+		"inactiveAfterDays": int(deviceInactivityLimit.Hours() / 24),
+	})
+}
+
+// UnlinkDevice removes a companion device and signs it out. A linked device
+// may also unlink itself.
+func (s *Service) UnlinkDevice(c *gin.Context) {
+	userID := c.GetString("userId")
+	deviceID := c.Param("deviceId")
+
+	result, err := s.db.Exec(`DELETE FROM devices WHERE id = $1 AND user_id = $2`, deviceID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlink device"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	s.revokeDeviceSessions(c, userID, deviceID, "device_unlinked")
+	s.notifyUser(userID, map[string]interface{}{
+		"type":     "device_unlinked",
+		"deviceId": deviceID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "device unlinked"})
+}
+
+// pruneDevices unlinks companion devices that were inactive for longer than
+// deviceInactivityLimit or whose sessions have all ended. Devices still
+// being linked have no session yet and are kept.
+func (s *Service) pruneDevices(c *gin.Context, userID string) {
+	query := `
+		DELETE FROM devices d
+		WHERE d.user_id = $1 AND (
+			d.last_seen < $2 OR d.created_at < NOW() - INTERVAL '1 minute' AND NOT EXISTS (
+				SELECT 1 FROM sessions s
+				WHERE s.device_id = d.id AND s.revoked_at IS NULL AND s.expires_at > NOW()
+			)
+		)
+		RETURNING d.id
+	`
+	rows, err := s.db.Query(query, userID, time.Now().Add(-deviceInactivityLimit))
+	if err != nil {
+		log.Printf("Failed to prune devices of %s: %v", userID, err)
+		return
+	}
+	var deviceIDs []string
+	for rows.Next() {
+		var deviceID string
+		if rows.Scan(&deviceID) == nil {
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+	}
+	rows.Close()
+
+	for _, deviceID := range deviceIDs {
+		s.revokeDeviceSessions(c, userID, deviceID, "device_inactive")
+	}
+}
+
+// revokeDeviceSessions revokes every session of a linked device
+func (s *Service) revokeDeviceSessions(c *gin.Context, userID, deviceID, reason string) {
+	var sessionIDs []string
+	query := `
+		UPDATE sessions SET revoked_at = $1, revoked_reason = $2
+		WHERE user_id = $3 AND device_id = $4 AND revoked_at IS NULL
+		RETURNING id
+	`
+	rows, err := s.db.Query(query, time.Now(), reason, userID, deviceID)
+	if err != nil {
+		log.Printf("Failed to revoke sessions of device %s: %v", deviceID, err)
+		return
+	}
+	for rows.Next() {
+		var sessionID string
+		if rows.Scan(&sessionID) == nil {
+			sessionIDs = append(sessionIDs, sessionID)
+		}
+	}
+	rows.Close()
+
+	if len(sessionIDs) > 0 {
+		s.securityEvent(c, userID, security.SessionRevoked, map[string]interface{}{
+			"sessionIds": sessionIDs,
+			"deviceId":   deviceID,
+			"reason":     reason,
+		})
+	}
+	for _, sessionID := range sessionIDs {
+		for _, hook := range s.sessionRevokedHooks {
+			hook(userID, sessionID)
+		}
+	}
+}
+
+// notifyUser delivers an event to the user's own connected devices
+func (s *Service) notifyUser(userID string, event map[string]interface{}) {
+	for _, hook := range s.userEventHooks {
+		hook(userID, event)
+	}
+}
+
+// unlinkAllDevices removes every companion device, e.g. when the account
+// moves to a new primary device
+func (s *Service) unlinkAllDevices(userID string) {
+	if _, err := s.db.Exec(`DELETE FROM devices WHERE user_id = $1`, userID); err != nil {
+		log.Printf("Failed to unlink devices of %s: %v", userID, err)
+	}
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxDiscoveryBatch is the most phone hashes accepted per request
+	maxDiscoveryBatch = 500
+	// discoveryDailyQuota is how many phone hashes a user may look up per day
+	discoveryDailyQuota = 2000
+	// minPhoneHashPrefix is the shortest accepted hash prefix in hex digits
+	// (64 bits), short enough to truncate but long enough to avoid collisions
+	minPhoneHashPrefix = 16
+	minSearchLength    = 3
+)
+
+// likeEscaper escapes LIKE wildcards in user input
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// DiscoverContactsRequest carries hashed address book numbers. Each entry is
+// a hex SHA-256 of an E.164 phone number, optionally truncated to a prefix.
+type DiscoverContactsRequest struct {
+	Hashes []string `json:"hashes" binding:"required"`
+}
+
+// DiscoveredContact is a registered user matching a submitted hash
+type DiscoveredContact struct {
+	Hash    string        `json:"hash"`
+	Profile PublicProfile `json:"profile"`
+}
+
+// PublicProfile is what other users see of an account. Email is never
+// included and the phone number only for contacts.
+type PublicProfile struct {
+	ID             string `json:"id"`
+	Username       string `json:"username"`
+	Handle         string `json:"handle,omitempty"`
+	DisplayName    string `json:"displayName,omitempty"`
+	About          string `json:"about,omitempty"`
+	AvatarURL      string `json:"avatarUrl,omitempty"`
+	AvatarThumbURL string `json:"avatarThumbUrl,omitempty"`
+	Phone          string `json:"phone,omitempty"`
+}
+
+// PhoneHash returns the discovery hash of an E.164 phone number
+func PhoneHash(phone string) string {
+	sum := sha256.Sum256([]byte(phone))
+	return hex.EncodeToString(sum[:])
+}
+
+// DiscoverContacts returns the registered users among hashed address book
+// numbers. Numbers never leave the client in clear text and unmatched hashes
+// reveal nothing; lookups are capped per user per day so the endpoint cannot
+// be used to enumerate the phone number space.
+func (s *Service) DiscoverContacts(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req DiscoverContactsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Hashes) > maxDiscoveryBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d hashes per request", maxDiscoveryBatch)})
+		return
+	}
+
+	seen := make(map[string]bool, len(req.Hashes))
+	var prefixes []string
+	for _, hash := range req.Hashes {
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if !validPhoneHashPrefix(hash) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("hashes must be %d to 64 hex digits", minPhoneHashPrefix)})
+			return
+		}
+		if !seen[hash] {
+			seen[hash] = true
+			prefixes = append(prefixes, hash)
+		}
+	}
+
+	quotaKey := fmt.Sprintf("discovery:%s:%s", userID, time.Now().UTC().Format("2006-01-02"))
+	used, ok := s.limiter.Consume(c.Request.Context(), quotaKey, int64(len(prefixes)), discoveryDailyQuota, 24*time.Hour)
+	if !ok {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "daily contact discovery limit reached, try again tomorrow"})
+		return
+	}
+
+	matches := []DiscoveredContact{}
+	for _, prefix := range prefixes {
+		found, err := s.usersByPhoneHash(c.Request.Context(), prefix, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "contact discovery failed"})
+			return
+		}
+		for _, user := range found {
+			matches = append(matches, DiscoveredContact{Hash: prefix, Profile: s.publicProfile(user, userID)})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"matches":   matches,
+		"remaining": discoveryDailyQuota - used,
+	})
+}
+
+// usersByPhoneHash returns active users whose phone hash starts with prefix,
+// leaving out the viewer and anyone blocked in either direction
+func (s *Service) usersByPhoneHash(ctx context.Context, prefix, viewerID string) ([]*User, error) {
+	query := `
+		SELECT id, username, phone, handle, display_name, about, avatar_key
+		FROM users
+		WHERE phone_hash LIKE $1 AND id != $2 AND status = 'active'
+		AND NOT EXISTS (
+			SELECT 1 FROM blocked_users b
+			WHERE (b.user_id = $2 AND b.blocked_user_id = users.id) OR (b.user_id = users.id AND b.blocked_user_id = $2)
+		)
+		LIMIT 2
+	`
+	rows, err := s.db.Query(query, prefix+"%", viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanPublicUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		s.withAvatarURLs(ctx, user)
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// publicProfile returns the parts of user that viewerID may see
+func (s *Service) publicProfile(user *User, viewerID string) PublicProfile {
+	visible := s.visibleProfile(user, viewerID)
+	profile := PublicProfile{
+		ID:             visible.ID,
+		Username:       visible.Username,
+		Handle:         visible.Handle,
+		DisplayName:    visible.DisplayName,
+		About:          visible.About,
+		AvatarURL:      visible.AvatarURL,
+		AvatarThumbURL: visible.AvatarThumbURL,
+	}
+	if viewerID == user.ID || (s.privacy != nil && s.privacy.IsContact(user.ID, viewerID)) {
+		profile.Phone = user.Phone
+	}
+	return profile
+}
+
+// scanPublicUser scans id, username, phone, handle, display_name, about, avatar_key
+func scanPublicUser(rows *sql.Rows) (*User, error) {
+	var user User
+	var handle, displayName, about, avatarKey sql.NullString
+	if err := rows.Scan(&user.ID, &user.Username, &user.Phone, &handle, &displayName, &about, &avatarKey); err != nil {
+		return nil, err
+	}
+	user.Handle = handle.String
+	user.DisplayName = displayName.String
+	user.About = about.String
+	user.avatarKey = avatarKey.String
+	return &user, nil
+}
+
+func validPhoneHashPrefix(hash string) bool {
+	if len(hash) < minPhoneHashPrefix || len(hash) > sha256.Size*2 {
+		return false
+	}
+	return strings.Trim(hash, "0123456789abcdef") == ""
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snaptalker/backend/internal/security"
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+const (
+	emailLoginLinkPurpose = "email-login"
+	// emailLoginHourlyQuota caps login emails per address per hour
+	emailLoginHourlyQuota = 5
+)
+
+// EmailLoginRequest asks for a passwordless login link and code
+type EmailLoginRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// EmailLoginVerifyRequest redeems either the code (with the email address)
+// or the token from the login link
+type EmailLoginVerifyRequest struct {
+	Email      string `json:"email"`
+	Code       string `json:"code"`
+	Token      string `json:"token"`
+	DeviceName string `json:"deviceName"`
+	Platform   string `json:"platform"`
+}
+
+// RequestEmailLogin emails a single-use login link and code to a verified
+// address. The response is the same whether or not the address belongs to
+// an account.
+func (s *Service) RequestEmailLogin(c *gin.Context) {
+	var req EmailLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	address := strings.ToLower(strings.TrimSpace(req.Email))
+
+	limits := []limitCheck{{loginEmailPolicy, address}, {loginIPPolicy, c.ClientIP()}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	response := gin.H{"message": "If the email address belongs to an account and is verified, a sign-in link has been sent"}
+
+	quotaKey := "email-login:" + address
+	if _, ok := s.limiter.Consume(c.Request.Context(), quotaKey, 1, emailLoginHourlyQuota, time.Hour); !ok {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many sign-in emails, try again later"})
+		return
+	}
+
+	userID, ok := s.emailLoginAccount(address)
+	var status string
+	if ok {
+		s.db.QueryRow(`SELECT status FROM users WHERE id = $1`, userID).Scan(&status)
+	}
+	if status != AccountActive {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// Sent in the background so response times do not reveal accounts
+	go func() {
+		if err := s.sendEmailLogin(address); err != nil {
+			log.Printf("Failed to send email login for %s: %v", userID, err)
+		}
+	}()
+	c.JSON(http.StatusOK, response)
+}
+
+// VerifyEmailLogin exchanges a login code or link token for the usual token
+// pair, subject to two-step verification like a password login
+func (s *Service) VerifyEmailLogin(c *gin.Context) {
+	var req EmailLoginVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Both the code and the link secret are checked against their own
+	// outstanding record; redeeming one invalidates the other
+	var address, secret string
+	var purpose OTPPurpose
+	switch {
+	case req.Token != "":
+		fields, err := crypto.VerifyFields(s.config.LinkSecret, emailLoginLinkPurpose, req.Token)
+		if err != nil || len(fields) != 2 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired sign-in link"})
+			return
+		}
+		address, secret, purpose = fields[0], fields[1], OTPPurposeEmailLink
+	case req.Email != "" && req.Code != "":
+		address, secret, purpose = strings.ToLower(strings.TrimSpace(req.Email)), req.Code, OTPPurposeEmailLogin
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token, or email and code required"})
+		return
+	}
+
+	limits := []limitCheck{{loginEmailPolicy, address}, {loginIPPolicy, c.ClientIP()}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	identifier := emailLoginIdentifier(address)
+	valid, err := s.consumeOTP(identifier, purpose, secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify sign-in"})
+		return
+	}
+	if !valid {
+		s.recordFailure(c, limits...)
+		userID, _ := s.emailLoginAccount(address)
+		s.securityEvent(c, userID, security.LoginFailed, map[string]interface{}{"method": "email"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired sign-in code"})
+		return
+	}
+	s.recordSuccess(c, limits[0])
+	s.db.Exec(`UPDATE otp_codes SET consumed_at = $1 WHERE identifier = $2 AND consumed_at IS NULL`, time.Now(), identifier)
+
+	userID, ok := s.emailLoginAccount(address)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired sign-in code"})
+		return
+	}
+	var user User
+	var totpEnabled bool
+	query := `SELECT id, username, phone, email, identity_key, status, created_at, totp_enabled FROM users WHERE id = $1`
+	err = s.db.QueryRow(query, userID).Scan(
+		&user.ID, &user.Username, &user.Phone, &user.Email, &user.IdentityKey, &user.Status, &user.CreatedAt, &totpEnabled,
+	)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired sign-in code"})
+		return
+	}
+	user.EmailVerified = true
+
+	s.completeLogin(c, &user, totpEnabled, req.DeviceName, req.Platform)
+}
+
+// emailLoginAccount returns the account whose verified email address is
+// address. users.email is only unique as written, so when addresses differing
+// in case belong to several accounts no account is returned rather than an
+// arbitrary one.
+func (s *Service) emailLoginAccount(address string) (string, bool) {
+	rows, err := s.db.Query(`SELECT id FROM users WHERE LOWER(email) = $1 AND email_verified = TRUE LIMIT 2`, address)
+	if err != nil {
+		return "", false
+	}
+	defer rows.Close()
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return "", false
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if len(userIDs) > 1 {
+		log.Printf("Refusing email login for %s: the address matches several accounts", address)
+	}
+	if rows.Err() != nil || len(userIDs) != 1 {
+		return "", false
+	}
+	return userIDs[0], true
+}
+
+// sendEmailLogin stores a fresh login code and link secret for the address
+// and emails both
+func (s *Service) sendEmailLogin(address string) error {
+	code, err := s.generateOTP()
+	if err != nil {
+		return err
+	}
+	raw, err := crypto.GenerateRandomBytes(32)
+	if err != nil {
+		return err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+
+	identifier := emailLoginIdentifier(address)
+	if err := s.storeOTP(identifier, OTPPurposeEmailLogin, code); err != nil {
+		return err
+	}
+	if err := s.storeOTP(identifier, OTPPurposeEmailLink, secret); err != nil {
+		return err
+	}
+
+	token := crypto.SignFields(s.config.LinkSecret, emailLoginLinkPurpose, address, secret)
+	link := strings.TrimSuffix(s.config.PublicURL, "/") + "/login/email?token=" + url.QueryEscape(token)
+	return s.emailService.SendLoginLink(address, link, code, formatValidity(otpTTL[OTPPurposeEmailLogin]))
+}
+
+// emailLoginIdentifier keeps email login codes apart from phone-keyed codes
+func emailLoginIdentifier(address string) string {
+	return "email:" + address
+}
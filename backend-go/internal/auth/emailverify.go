@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+const (
+	emailVerificationPurpose = "email-verification"
+	emailVerificationTTL     = 24 * time.Hour
+	// emailVerificationQuota caps verification emails per user per day
+	emailVerificationQuota = 5
+)
+
+// VerifyEmailRequest carries the token from a verification link
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// VerifyEmail confirms an email address from a signed verification link.
+// For an email change this is when the new address replaces the old one.
+func (s *Service) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fields, err := crypto.VerifyFields(s.config.LinkSecret, emailVerificationPurpose, req.Token)
+	if err != nil || len(fields) != 3 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid verification link"})
+		return
+	}
+	userID, address := fields[0], fields[1]
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "verification link has expired, request a new one"})
+		return
+	}
+
+	var current string
+	var pending sql.NullString
+	var verified bool
+	query := `SELECT email, pending_email, email_verified FROM users WHERE id = $1`
+	if err := s.db.QueryRow(query, userID).Scan(&current, &pending, &verified); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid verification link"})
+		return
+	}
+
+	switch {
+	case pending.Valid && pending.String == address:
+		now := time.Now()
+		query := `
+			UPDATE users SET email = pending_email, pending_email = NULL, email_verified = TRUE, email_verified_at = $1, updated_at = $1
+			WHERE id = $2 AND pending_email = $3
+		`
+		if _, err := s.db.Exec(query, now, userID, address); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+			return
+		}
+		s.notifyEmailChanged(current, address, now)
+	case current == address && !verified:
+		query := `UPDATE users SET email_verified = TRUE, email_verified_at = $1 WHERE id = $2 AND email = $3`
+		if _, err := s.db.Exec(query, time.Now(), userID, address); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+			return
+		}
+	case current == address && verified:
+		// Link opened twice
+	default:
+		// Superseded by a later change
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid verification link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email address verified", "email": address})
+}
+
+// ResendEmailVerification sends a new verification link for the pending or
+// unverified email address of the current user
+func (s *Service) ResendEmailVerification(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var current string
+	var pending sql.NullString
+	var verified bool
+	query := `SELECT email, pending_email, email_verified FROM users WHERE id = $1`
+	if err := s.db.QueryRow(query, userID).Scan(&current, &pending, &verified); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	address := current
+	if pending.Valid {
+		address = pending.String
+	} else if verified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email address is already verified"})
+		return
+	}
+
+	quotaKey := "email-verification:" + userID + ":" + time.Now().UTC().Format("2006-01-02")
+	if _, ok := s.limiter.Consume(c.Request.Context(), quotaKey, 1, emailVerificationQuota, 24*time.Hour); !ok {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many verification emails today, try again tomorrow"})
+		return
+	}
+
+	if err := s.sendEmailVerification(userID, address); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "verification email sent", "email": address})
+}
+
+// sendEmailVerification emails a signed, expiring verification link
+func (s *Service) sendEmailVerification(userID, address string) error {
+	expiresAt := time.Now().Add(emailVerificationTTL)
+	token := crypto.SignFields(s.config.LinkSecret, emailVerificationPurpose, userID, address, strconv.FormatInt(expiresAt.Unix(), 10))
+	link := strings.TrimSuffix(s.config.PublicURL, "/") + "/verify-email?token=" + url.QueryEscape(token)
+
+	if err := s.emailService.SendEmailVerification(address, link, expiresAt); err != nil {
+		log.Printf("Failed to send email verification for %s: %v", userID, err)
+		return err
+	}
+	return nil
+}
+
+// notifyEmailChanged tells the previous address about an email change
+func (s *Service) notifyEmailChanged(oldAddress, newAddress string, changedAt time.Time) {
+	go func() {
+		if err := s.emailService.SendEmailChanged(oldAddress, maskEmail(newAddress), changedAt); err != nil {
+			log.Printf("Failed to send email change notification: %v", err)
+		}
+	}()
+}
+
+// verifiedEmail returns the user's email address if it has been verified.
+// Account and security emails only go to verified addresses.
+func (s *Service) verifiedEmail(userID string) (string, bool) {
+	var address string
+	var verified bool
+	query := `SELECT email, email_verified FROM users WHERE id = $1`
+	if err := s.db.QueryRow(query, userID).Scan(&address, &verified); err != nil || !verified || address == "" {
+		return "", false
+	}
+	return address, true
+}
+
+// maskEmail hides most of the local part of an address, e.g. j***@example.com
+func maskEmail(address string) string {
+	at := strings.LastIndexByte(address, '@')
+	if at < 1 {
+		return "***"
+	}
+	return address[:1] + "***" + address[at:]
+}
@@ -0,0 +1,620 @@
+package auth
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Data export job states stored in data_exports.status
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+	ExportExpired = "expired"
+)
+
+const (
+	exportTimeout   = 30 * time.Minute   // pending jobs older than this are considered dead
+	exportCooldown  = 24 * time.Hour     // minimum time between two successful exports
+	exportRetention = 7 * 24 * time.Hour // how long a finished archive is kept
+	exportLinkTTL   = 24 * time.Hour     // validity of a presigned download link
+)
+
+const exportReadme = `SnapTalker account information
+==============================
+
+account.json        profile, settings and signed-in devices
+contacts.json       people you have exchanged messages with
+conversations.json  per-conversation message counts and dates
+messages.json       your messages as stored on the server (end-to-end encrypted ciphertext)
+reactions.json      reactions you added to messages
+calls.json          call history (metadata only)
+media/manifest.json list of media files you uploaded, included under media/
+
+Message contents are encrypted on your devices. SnapTalker cannot read them and
+this export does not contain the keys to decrypt them.
+`
+
+// DataExport is a "Request account info" job
+type DataExport struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	SizeBytes   int64      `json:"sizeBytes,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	DownloadURL string     `json:"downloadUrl,omitempty"`
+}
+
+// RequestDataExport starts an asynchronous export of the current user's data.
+// The user is emailed a download link once the archive is ready.
+func (s *Service) RequestDataExport(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	if s.minio == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "data export is not available"})
+		return
+	}
+
+	latest, _, err := s.latestDataExport(userID)
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check existing exports"})
+		return
+	}
+	if err == nil {
+		if latest.Status == ExportPending && time.Since(latest.CreatedAt) < exportTimeout {
+			c.JSON(http.StatusConflict, gin.H{"error": "an export is already in progress", "export": latest})
+			return
+		}
+		if latest.Status == ExportReady {
+			if wait := exportCooldown - time.Since(latest.CreatedAt); wait > 0 {
+				seconds := int(math.Ceil(wait.Seconds()))
+				c.Header("Retry-After", strconv.Itoa(seconds))
+				c.JSON(http.StatusTooManyRequests, gin.H{
+					"error":      "an export was created recently, download it or try again later",
+					"retryAfter": seconds,
+				})
+				return
+			}
+		}
+	}
+
+	exportID := uuid.New().String()
+	query := `INSERT INTO data_exports (id, user_id, status, created_at) VALUES ($1, $2, $3, $4)`
+	if _, err := s.db.Exec(query, exportID, userID, ExportPending, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start export"})
+		return
+	}
+
+	go s.runDataExport(exportID, userID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "export started, you will receive an email when it is ready",
+		"exportId": exportID,
+		"status":   ExportPending,
+	})
+}
+
+// GetDataExport returns the state of the current user's latest export and a
+// fresh download link when it is ready
+func (s *Service) GetDataExport(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	export, objectName, err := s.latestDataExport(userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no export requested"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get export"})
+		return
+	}
+
+	if export.Status == ExportReady && export.ExpiresAt != nil && s.minio != nil {
+		if time.Now().After(*export.ExpiresAt) {
+			export.Status = ExportExpired
+		} else {
+			url, err := s.minio.GetPresignedURL(c.Request.Context(), objectName, int(exportLinkTTL.Seconds()))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create download link"})
+				return
+			}
+			export.DownloadURL = url
+		}
+	}
+
+	c.JSON(http.StatusOK, export)
+}
+
+func (s *Service) latestDataExport(userID string) (*DataExport, string, error) {
+	var export DataExport
+	var objectName, exportErr sql.NullString
+	var sizeBytes sql.NullInt64
+	var completedAt, expiresAt sql.NullTime
+	query := `
+		SELECT id, status, object_name, size_bytes, error, created_at, completed_at, expires_at
+		FROM data_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	err := s.db.QueryRow(query, userID).Scan(
+		&export.ID, &export.Status, &objectName, &sizeBytes, &exportErr, &export.CreatedAt, &completedAt, &expiresAt,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	export.SizeBytes = sizeBytes.Int64
+	export.Error = exportErr.String
+	export.CompletedAt = nullTime(completedAt)
+	export.ExpiresAt = nullTime(expiresAt)
+	return &export, objectName.String, nil
+}
+
+// runDataExport builds the archive, uploads it and notifies the user
+func (s *Service) runDataExport(exportID, userID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	objectName, size, err := s.buildDataExport(ctx, userID, exportID)
+	if err != nil {
+		log.Printf("Data export %s for %s failed: %v", exportID, userID, err)
+		s.db.Exec(`UPDATE data_exports SET status = $1, error = $2, completed_at = $3 WHERE id = $4`,
+			ExportFailed, "export could not be created", time.Now(), exportID)
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(exportRetention)
+	query := `
+		UPDATE data_exports SET status = $1, object_name = $2, size_bytes = $3, completed_at = $4, expires_at = $5
+		WHERE id = $6
+	`
+	if _, err := s.db.Exec(query, ExportReady, objectName, size, now, expiresAt, exportID); err != nil {
+		log.Printf("Failed to mark data export %s ready: %v", exportID, err)
+		return
+	}
+
+	// The download link is only mailed to a verified address; otherwise it
+	// is available through GetDataExport
+	email, ok := s.verifiedEmail(userID)
+	if !ok {
+		return
+	}
+	url, err := s.minio.GetPresignedURL(ctx, objectName, int(exportLinkTTL.Seconds()))
+	if err != nil {
+		log.Printf("Failed to create download link for export %s: %v", exportID, err)
+		return
+	}
+	if err := s.emailService.SendDataExportReady(email, url, now.Add(exportLinkTTL)); err != nil {
+		log.Printf("Failed to send export notification to %s: %v", userID, err)
+	}
+}
+
+// buildDataExport writes the user's data to a temporary ZIP file and uploads
+// it to MinIO, returning the object name and archive size
+func (s *Service) buildDataExport(ctx context.Context, userID, exportID string) (string, int64, error) {
+	f, err := os.CreateTemp("", "snaptalker-export-*.zip")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	readme, err := zw.Create("README.txt")
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := io.WriteString(readme, exportReadme); err != nil {
+		return "", 0, err
+	}
+
+	sections := []struct {
+		name    string
+		collect func(string) (interface{}, error)
+	}{
+		{"account.json", s.exportAccount},
+		{"contacts.json", s.exportContacts},
+		{"conversations.json", s.exportConversations},
+		{"messages.json", s.exportMessages},
+		{"reactions.json", s.exportReactions},
+		{"calls.json", s.exportCalls},
+	}
+	for _, section := range sections {
+		data, err := section.collect(userID)
+		if err != nil {
+			return "", 0, fmt.Errorf("%s: %w", section.name, err)
+		}
+		if err := writeZipJSON(zw, section.name, data); err != nil {
+			return "", 0, err
+		}
+	}
+
+	if err := s.exportMedia(ctx, zw, userID); err != nil {
+		return "", 0, fmt.Errorf("media: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return "", 0, err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+
+	objectName := fmt.Sprintf("exports/%s/%s.zip", userID, exportID)
+	if _, err := s.minio.UploadStream(ctx, objectName, f, size, "application/zip"); err != nil {
+		return "", 0, err
+	}
+	return objectName, size, nil
+}
+
+func (s *Service) exportAccount(userID string) (interface{}, error) {
+	var user User
+	var lastSeen sql.NullTime
+	var totpEnabled, emailVerified bool
+	var registrationLock, displayName, about sql.NullString
+	query := `
+		SELECT id, username, phone, email, email_verified, status, created_at, last_seen, totp_enabled, registration_lock_hash, display_name, about
+		FROM users WHERE id = $1
+	`
+	err := s.db.QueryRow(query, userID).Scan(
+		&user.ID, &user.Username, &user.Phone, &user.Email, &emailVerified, &user.Status, &user.CreatedAt, &lastSeen, &totpEnabled, &registrationLock,
+		&displayName, &about,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT device_name, platform, ip_address, created_at, last_used_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []gin.H{}
+	for rows.Next() {
+		var deviceName, platform, ipAddress sql.NullString
+		var createdAt, lastUsedAt time.Time
+		if err := rows.Scan(&deviceName, &platform, &ipAddress, &createdAt, &lastUsedAt); err != nil {
+			return nil, err
+		}
+		devices = append(devices, gin.H{
+			"deviceName": deviceName.String,
+			"platform":   platform.String,
+			"ipAddress":  ipAddress.String,
+			"createdAt":  createdAt,
+			"lastUsedAt": lastUsedAt,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	identityRows, err := s.db.Query(`
+		SELECT issuer, subject, email, created_at, last_login_at
+		FROM user_identities WHERE user_id = $1 ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer identityRows.Close()
+
+	identities := []gin.H{}
+	for identityRows.Next() {
+		var issuer, subject string
+		var email sql.NullString
+		var createdAt time.Time
+		var lastLoginAt sql.NullTime
+		if err := identityRows.Scan(&issuer, &subject, &email, &createdAt, &lastLoginAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, gin.H{
+			"issuer":      issuer,
+			"subject":     subject,
+			"email":       email.String,
+			"linkedAt":    createdAt,
+			"lastLoginAt": nullTime(lastLoginAt),
+		})
+	}
+
+	var privacySettings interface{}
+	if s.privacy != nil {
+		if settings, err := s.privacy.Settings(userID); err == nil {
+			privacySettings = settings
+		}
+	}
+
+	return gin.H{
+		"profile": gin.H{
+			"id":            user.ID,
+			"username":      user.Username,
+			"displayName":   displayName.String,
+			"about":         about.String,
+			"phone":         user.Phone,
+			"email":         user.Email,
+			"emailVerified": emailVerified,
+			"status":        user.Status,
+			"createdAt":     user.CreatedAt,
+			"lastSeen":      nullTime(lastSeen),
+		},
+		"settings": gin.H{
+			"twoFactorEnabled":        totpEnabled,
+			"registrationLockEnabled": registrationLock.Valid && registrationLock.String != "",
+			"privacy":                 privacySettings,
+		},
+		"devices":          devices,
+		"linkedIdentities": identities,
+		"generatedAt":      time.Now(),
+	}, identityRows.Err()
+}
+
+func (s *Service) exportContacts(userID string) (interface{}, error) {
+	rows, err := s.db.Query(`
+		SELECT p.other_id, COALESCE(u.username, '')
+		FROM (
+			SELECT DISTINCT CASE WHEN sender_id = $1 THEN recipient_id ELSE sender_id END AS other_id
+			FROM messages
+			WHERE sender_id = $1 OR recipient_id = $1
+		) p
+		LEFT JOIN users u ON u.id = p.other_id
+		ORDER BY 2
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []gin.H{}
+	for rows.Next() {
+		var id, username string
+		if err := rows.Scan(&id, &username); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, gin.H{"userId": id, "username": username})
+	}
+	return contacts, rows.Err()
+}
+
+func (s *Service) exportConversations(userID string) (interface{}, error) {
+	rows, err := s.db.Query(`
+		SELECT CASE WHEN sender_id = $1 THEN recipient_id ELSE sender_id END AS other_id,
+			COUNT(*), COUNT(*) FILTER (WHERE sender_id = $1), MIN(timestamp), MAX(timestamp)
+		FROM messages
+		WHERE sender_id = $1 OR recipient_id = $1
+		GROUP BY 1
+		ORDER BY 5 DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []gin.H{}
+	for rows.Next() {
+		var otherID string
+		var total, sent int64
+		var first, last time.Time
+		if err := rows.Scan(&otherID, &total, &sent, &first, &last); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, gin.H{
+			"userId":         otherID,
+			"messageCount":   total,
+			"sentCount":      sent,
+			"firstMessageAt": first,
+			"lastMessageAt":  last,
+		})
+	}
+	return conversations, rows.Err()
+}
+
+// exportMessages includes message bodies only as the ciphertext stored on the
+// server; rows not marked encrypted are exported without a body
+func (s *Service) exportMessages(userID string) (interface{}, error) {
+	rows, err := s.db.Query(`
+		SELECT id, sender_id, recipient_id, content, content_type, encrypted, timestamp, status, reply_to_id
+		FROM messages
+		WHERE sender_id = $1 OR recipient_id = $1
+		ORDER BY timestamp
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []gin.H{}
+	for rows.Next() {
+		var id, senderID, recipientID, content string
+		var contentType, status, replyToID sql.NullString
+		var encrypted sql.NullBool
+		var timestamp time.Time
+		if err := rows.Scan(&id, &senderID, &recipientID, &content, &contentType, &encrypted, &timestamp, &status, &replyToID); err != nil {
+			return nil, err
+		}
+		message := gin.H{
+			"id":          id,
+			"senderId":    senderID,
+			"recipientId": recipientID,
+			"contentType": contentType.String,
+			"timestamp":   timestamp,
+			"status":      status.String,
+		}
+		if encrypted.Valid && encrypted.Bool {
+			message["ciphertext"] = content
+		}
+		if replyToID.Valid {
+			message["replyToId"] = replyToID.String
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (s *Service) exportReactions(userID string) (interface{}, error) {
+	rows, err := s.db.Query(`
+		SELECT message_id, emoji, created_at FROM message_reactions
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactions := []gin.H{}
+	for rows.Next() {
+		var messageID, emoji string
+		var createdAt time.Time
+		if err := rows.Scan(&messageID, &emoji, &createdAt); err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, gin.H{"messageId": messageID, "emoji": emoji, "createdAt": createdAt})
+	}
+	return reactions, rows.Err()
+}
+
+func (s *Service) exportCalls(userID string) (interface{}, error) {
+	rows, err := s.db.Query(`
+		SELECT id, caller_id, callee_id, call_type, status, started_at, answered_at, ended_at
+		FROM call_logs
+		WHERE caller_id = $1 OR callee_id = $1
+		ORDER BY started_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calls := []gin.H{}
+	for rows.Next() {
+		var id, callerID, calleeID, callType, status string
+		var startedAt time.Time
+		var answeredAt, endedAt sql.NullTime
+		if err := rows.Scan(&id, &callerID, &calleeID, &callType, &status, &startedAt, &answeredAt, &endedAt); err != nil {
+			return nil, err
+		}
+		direction := "incoming"
+		if callerID == userID {
+			direction = "outgoing"
+		}
+		calls = append(calls, gin.H{
+			"callId":     id,
+			"direction":  direction,
+			"callerId":   callerID,
+			"calleeId":   calleeID,
+			"callType":   callType,
+			"status":     status,
+			"startedAt":  startedAt,
+			"answeredAt": nullTime(answeredAt),
+			"endedAt":    nullTime(endedAt),
+		})
+	}
+	return calls, rows.Err()
+}
+
+// exportMedia copies the user's uploaded media into the archive under media/
+// together with a manifest
+func (s *Service) exportMedia(ctx context.Context, zw *zip.Writer, userID string) error {
+	prefix := fmt.Sprintf("media/%s/", userID)
+	objects, err := s.minio.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	manifest := []gin.H{}
+	for _, object := range objects {
+		data, err := s.minio.Download(ctx, object.Key)
+		if err != nil {
+			return err
+		}
+		path := "media/" + strings.TrimPrefix(object.Key, prefix)
+		w, err := zw.Create(path)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		manifest = append(manifest, gin.H{
+			"file":         path,
+			"size":         object.Size,
+			"contentType":  object.ContentType,
+			"lastModified": object.LastModified,
+		})
+	}
+	return writeZipJSON(zw, "media/manifest.json", manifest)
+}
+
+// purgeExpiredExports deletes archives whose retention period has passed
+func (s *Service) purgeExpiredExports(ctx context.Context) {
+	if s.minio == nil {
+		return
+	}
+	rows, err := s.db.Query(`SELECT id, object_name FROM data_exports WHERE status = $1 AND expires_at < NOW()`, ExportReady)
+	if err != nil {
+		log.Printf("Failed to list expired exports: %v", err)
+		return
+	}
+	type expired struct{ id, objectName string }
+	var exports []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.objectName); err == nil {
+			exports = append(exports, e)
+		}
+	}
+	rows.Close()
+
+	for _, e := range exports {
+		if err := s.minio.Delete(ctx, e.objectName); err != nil {
+			log.Printf("Failed to delete expired export %s: %v", e.id, err)
+			continue
+		}
+		s.db.Exec(`UPDATE data_exports SET status = $1, object_name = NULL WHERE id = $2`, ExportExpired, e.id)
+	}
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
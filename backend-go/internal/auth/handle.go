@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+const (
+	minHandleLength = 5
+	maxHandleLength = 32
+	// handleChangeCooldown is how long a user must wait between handle
+	// changes, so handles cannot be cycled to squat or impersonate
+	handleChangeCooldown = 7 * 24 * time.Hour
+	profileLinkPurpose   = "profile-link"
+)
+
+// reservedHandles cannot be claimed; handles containing one of
+// reservedHandleWords are rejected as well
+var (
+	reservedHandles = map[string]bool{
+		"admin": true, "administrator": true, "root": true, "system": true, "support": true,
+		"help": true, "security": true, "moderator": true, "official": true, "staff": true,
+		"team": true, "settings": true, "account": true, "login": true, "signup": true,
+		"everyone": true, "nobody": true, "null": true, "undefined": true, "anonymous": true,
+	}
+	reservedHandleWords = []string{"snaptalker", "admin"}
+)
+
+// SetHandleRequest claims or changes the current user's handle
+type SetHandleRequest struct {
+	Handle string `json:"handle" binding:"required"`
+}
+
+// ProfileLink is a shareable link to a profile
+type ProfileLink struct {
+	Token     string `json:"token"`
+	URL       string `json:"url"`
+	QRPayload string `json:"qrPayload"`
+}
+
+// SetHandle claims or changes the current user's @handle
+func (s *Service) SetHandle(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req SetHandleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	handle := strings.TrimPrefix(strings.TrimSpace(req.Handle), "@")
+	if err := validateHandle(handle); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var current sql.NullString
+	var changedAt sql.NullTime
+	err := s.db.QueryRow(`SELECT handle, handle_changed_at FROM users WHERE id = $1`, userID).Scan(&current, &changedAt)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if current.String == handle {
+		c.JSON(http.StatusOK, gin.H{"handle": handle})
+		return
+	}
+	if s.rejectDuringHandleCooldown(c, changedAt) {
+		return
+	}
+
+	var taken bool
+	s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(handle) = LOWER($1) AND id != $2)`, handle, userID).Scan(&taken)
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "handle already taken"})
+		return
+	}
+
+	// Changing only the case of the own handle is not a new handle
+	query := `
+		UPDATE users SET handle = $1,
+			handle_changed_at = CASE WHEN LOWER(COALESCE(handle, '')) = LOWER($1) THEN handle_changed_at ELSE $2 END,
+			updated_at = $2
+		WHERE id = $3
+	`
+	if _, err := s.db.Exec(query, handle, time.Now(), userID); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{"error": "handle already taken"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update handle"})
+		return
+	}
+
+	if user, err := s.getUserByID(userID); err == nil {
+		s.withAvatarURLs(c.Request.Context(), user)
+		s.notifyProfileUpdated(user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"handle": handle})
+}
+
+// RemoveHandle releases the current user's handle. Removing counts as a
+// change for the cooldown.
+func (s *Service) RemoveHandle(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var current sql.NullString
+	var changedAt sql.NullTime
+	err := s.db.QueryRow(`SELECT handle, handle_changed_at FROM users WHERE id = $1`, userID).Scan(&current, &changedAt)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if !current.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no handle set"})
+		return
+	}
+	if s.rejectDuringHandleCooldown(c, changedAt) {
+		return
+	}
+
+	query := `UPDATE users SET handle = NULL, handle_changed_at = $1, updated_at = $1 WHERE id = $2`
+	if _, err := s.db.Exec(query, time.Now(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove handle"})
+		return
+	}
+
+	if user, err := s.getUserByID(userID); err == nil {
+		s.withAvatarURLs(c.Request.Context(), user)
+		s.notifyProfileUpdated(user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "handle removed"})
+}
+
+// GetUserByHandle looks up a user by exact handle, ignoring case
+func (s *Service) GetUserByHandle(c *gin.Context) {
+	viewerID := c.GetString("userId")
+	handle := strings.TrimPrefix(c.Param("handle"), "@")
+
+	var userID string
+	query := `SELECT id FROM users WHERE LOWER(handle) = LOWER($1) AND status = $2`
+	if err := s.db.QueryRow(query, handle, AccountActive).Scan(&userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	s.respondPublicProfile(c, userID, viewerID)
+}
+
+// GetProfileLink returns the current user's shareable profile link
+func (s *Service) GetProfileLink(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var version int
+	if err := s.db.QueryRow(`SELECT profile_link_version FROM users WHERE id = $1`, userID).Scan(&version); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	c.JSON(http.StatusOK, s.profileLink(userID, version))
+}
+
+// ResetProfileLink invalidates every previously shared profile link
+func (s *Service) ResetProfileLink(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var version int
+	query := `UPDATE users SET profile_link_version = profile_link_version + 1 WHERE id = $1 RETURNING profile_link_version`
+	if err := s.db.QueryRow(query, userID).Scan(&version); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset profile link"})
+		return
+	}
+	c.JSON(http.StatusOK, s.profileLink(userID, version))
+}
+
+// ResolveProfileLink returns the profile a shared link points to
+func (s *Service) ResolveProfileLink(c *gin.Context) {
+	viewerID := c.GetString("userId")
+
+	fields, err := crypto.VerifyFields(s.config.LinkSecret, profileLinkPurpose, c.Param("token"))
+	if err != nil || len(fields) != 2 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invalid profile link"})
+		return
+	}
+	userID := fields[0]
+	version, err := strconv.Atoi(fields[1])
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invalid profile link"})
+		return
+	}
+
+	var current int
+	query := `SELECT profile_link_version FROM users WHERE id = $1 AND status = $2`
+	if err := s.db.QueryRow(query, userID, AccountActive).Scan(&current); err != nil || current != version {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invalid profile link"})
+		return
+	}
+	s.respondPublicProfile(c, userID, viewerID)
+}
+
+// respondPublicProfile writes the public profile of userID as viewerID sees
+// it; users who blocked each other are not found
+func (s *Service) respondPublicProfile(c *gin.Context, userID, viewerID string) {
+	if s.privacy != nil && s.privacy.IsBlocked(userID, viewerID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	s.withAvatarURLs(c.Request.Context(), user)
+	c.JSON(http.StatusOK, s.publicProfile(user, viewerID))
+}
+
+func (s *Service) profileLink(userID string, version int) ProfileLink {
+	token := crypto.SignFields(s.config.LinkSecret, profileLinkPurpose, userID, strconv.Itoa(version))
+	return ProfileLink{
+		Token:     token,
+		URL:       strings.TrimSuffix(s.config.PublicURL, "/") + "/u/" + token,
+		QRPayload: "snaptalker://u/" + token,
+	}
+}
+
+// rejectDuringHandleCooldown writes a 429 response when the handle was
+// changed too recently. Claiming a first handle is always allowed.
+func (s *Service) rejectDuringHandleCooldown(c *gin.Context, changedAt sql.NullTime) bool {
+	if !changedAt.Valid {
+		return false
+	}
+	retryAfter := time.Until(changedAt.Time.Add(handleChangeCooldown))
+	if retryAfter <= 0 {
+		return false
+	}
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      "handle was changed recently, try again later",
+		"retryAfter": seconds,
+	})
+	return true
+}
+
+// validateHandle checks the format of a handle (without the leading @)
+func validateHandle(handle string) error {
+	if len(handle) < minHandleLength || len(handle) > maxHandleLength {
+		return fmt.Errorf("handle must be %d to %d characters", minHandleLength, maxHandleLength)
+	}
+	for i, r := range handle {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case (r >= '0' && r <= '9') || r == '_':
+			if i == 0 {
+				return fmt.Errorf("handle must start with a letter")
+			}
+		default:
+			return fmt.Errorf("handle may only contain letters, digits and underscores")
+		}
+	}
+	if strings.HasSuffix(handle, "_") || strings.Contains(handle, "__") {
+		return fmt.Errorf("handle cannot end with or repeat underscores")
+	}
+
+	lower := strings.ToLower(handle)
+	if reservedHandles[lower] {
+		return fmt.Errorf("handle is reserved")
+	}
+	for _, word := range reservedHandleWords {
+		if strings.Contains(lower, word) {
+			return fmt.Errorf("handle is reserved")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+// Registration modes
+const (
+	RegistrationOpen   = "open"   // anyone can register, invite codes are optional
+	RegistrationInvite = "invite" // a valid invite code is required to register
+	RegistrationClosed = "closed" // no new accounts; existing ones can still move devices
+)
+
+const (
+	// DefaultInviteQuota is how many invite codes each user may hand out
+	DefaultInviteQuota = 5
+	// userInviteTTL is how long an invite code created by a user is valid
+	userInviteTTL = 30 * 24 * time.Hour
+	// maxInviteBatch caps how many codes an operator can mint at once
+	maxInviteBatch = 1000
+)
+
+var errInvalidInvite = errors.New("invalid or expired invite code")
+
+// Invite is an invite code with its usage
+type Invite struct {
+	Code      string     `json:"code"`
+	MaxUses   int        `json:"maxUses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Revoked   bool       `json:"revoked"`
+	BatchID   string     `json:"batchId,omitempty"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+	Note      string     `json:"note,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// MintInvitesRequest mints a batch of invite codes
+type MintInvitesRequest struct {
+	Count     int        `json:"count" binding:"required,min=1"`
+	MaxUses   int        `json:"maxUses" binding:"omitempty,min=1,max=100000"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	Note      string     `json:"note" binding:"max=200"`
+}
+
+// ValidRegistrationMode reports whether mode is one of the registration modes
+func ValidRegistrationMode(mode string) bool {
+	return mode == RegistrationOpen || mode == RegistrationInvite || mode == RegistrationClosed
+}
+
+// GetRegistrationMode tells clients whether registering needs an invite code
+func (s *Service) GetRegistrationMode(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"mode":           s.config.RegistrationMode,
+		"inviteRequired": s.config.RegistrationMode == RegistrationInvite,
+	})
+}
+
+// GetMyInvites lists the invite codes the current user created, how many
+// more they may create and who joined with them
+func (s *Service) GetMyInvites(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	invites, err := s.listInvites(`WHERE created_by = $1 AND batch_id IS NULL`, maxAdminPageSize, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invites"})
+		return
+	}
+
+	rows, err := s.db.Query(`SELECT id, username, created_at FROM users WHERE invited_by = $1 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invites"})
+		return
+	}
+	defer rows.Close()
+	invited := []gin.H{}
+	for rows.Next() {
+		var id, username string
+		var joinedAt time.Time
+		if rows.Scan(&id, &username, &joinedAt) == nil {
+			invited = append(invited, gin.H{"userId": id, "username": username, "joinedAt": joinedAt})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invites":   invites,
+		"remaining": s.config.InviteQuota - s.usedInviteQuota(userID),
+		"quota":     s.config.InviteQuota,
+		"invited":   invited,
+	})
+}
+
+// CreateInvite creates a single-use invite code counted against the
+// current user's quota
+func (s *Service) CreateInvite(c *gin.Context) {
+	userID := c.GetString("userId")
+	if s.config.RegistrationMode != RegistrationInvite {
+		c.JSON(http.StatusConflict, gin.H{"error": "invite codes are not needed to register", "mode": s.config.RegistrationMode})
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invite"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the inviter so concurrent requests cannot exceed the quota
+	if _, err := tx.Exec(`SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invite"})
+		return
+	}
+	var used int
+	if err := tx.QueryRow(usedInviteQuotaQuery, userID).Scan(&used); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invite"})
+		return
+	}
+	if used >= s.config.InviteQuota {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no invites left", "quota": s.config.InviteQuota})
+		return
+	}
+
+	code, err := newInviteCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invite"})
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(userInviteTTL)
+	query := `INSERT INTO invite_codes (code, created_by, max_uses, expires_at, created_at) VALUES ($1, $2, 1, $3, $4)`
+	if _, err := tx.Exec(query, code, userID, expiresAt, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invite"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invite"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"invite":    Invite{Code: formatInviteCode(code), MaxUses: 1, ExpiresAt: &expiresAt, CreatedBy: userID, CreatedAt: now},
+		"remaining": s.config.InviteQuota - used - 1,
+	})
+}
+
+// RevokeInvite withdraws an unused invite code of the current user, which
+// returns it to the quota
+func (s *Service) RevokeInvite(c *gin.Context) {
+	userID := c.GetString("userId")
+	code := normalizeInviteCode(c.Param("code"))
+
+	query := `UPDATE invite_codes SET revoked_at = $1 WHERE code = $2 AND created_by = $3 AND batch_id IS NULL AND revoked_at IS NULL`
+	result, err := s.db.Exec(query, time.Now(), code, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke invite"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invite not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "invite revoked"})
+}
+
+// AdminMintInvites creates a batch of invite codes with an optional expiry
+// and a usage limit per code
+func (s *Service) AdminMintInvites(c *gin.Context) {
+	var req MintInvitesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Count > maxInviteBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many codes in one batch", "maxCount": maxInviteBatch})
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiry must be in the future"})
+		return
+	}
+	adminID := c.GetString("userId")
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to mint invites"})
+		return
+	}
+	defer tx.Rollback()
+
+	batchID := uuid.New().String()
+	now := time.Now()
+	codes := make([]string, 0, req.Count)
+	query := `
+		INSERT INTO invite_codes (code, created_by, batch_id, max_uses, expires_at, note, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)
+	`
+	for len(codes) < req.Count {
+		code, err := newInviteCode()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to mint invites"})
+			return
+		}
+		if _, err := tx.Exec(query, code, adminID, batchID, req.MaxUses, req.ExpiresAt, req.Note, now); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to mint invites"})
+			return
+		}
+		codes = append(codes, formatInviteCode(code))
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to mint invites"})
+		return
+	}
+
+	details := gin.H{"count": req.Count, "maxUses": req.MaxUses}
+	if req.ExpiresAt != nil {
+		details["expiresAt"] = req.ExpiresAt
+	}
+	s.auditAdminAction(c, "mint_invites", "", batchID, req.Note, details)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"batchId":   batchID,
+		"codes":     codes,
+		"maxUses":   req.MaxUses,
+		"expiresAt": req.ExpiresAt,
+	})
+}
+
+// AdminGetInvites lists invite codes, optionally of one batch or creator
+func (s *Service) AdminGetInvites(c *gin.Context) {
+	limit := adminPageSize(c)
+	var invites []Invite
+	var err error
+	switch {
+	case c.Query("batchId") != "":
+		invites, err = s.listInvites(`WHERE batch_id = $1`, limit, c.Query("batchId"))
+	case c.Query("userId") != "":
+		invites, err = s.listInvites(`WHERE created_by = $1`, limit, c.Query("userId"))
+	default:
+		invites, err = s.listInvites("", limit)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invites"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invites": invites, "mode": s.config.RegistrationMode})
+}
+
+// AdminRevokeInvite withdraws any invite code
+func (s *Service) AdminRevokeInvite(c *gin.Context) {
+	code := normalizeInviteCode(c.Param("code"))
+
+	result, err := s.db.Exec(`UPDATE invite_codes SET revoked_at = $1 WHERE code = $2 AND revoked_at IS NULL`, time.Now(), code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke invite"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invite not found"})
+		return
+	}
+	s.auditAdminAction(c, "revoke_invite", "", code, "", nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "invite revoked"})
+}
+
+// redeemInvite uses up one use of an invite code within the registration
+// transaction and returns who created it
+func redeemInvite(tx *sql.Tx, code string) (sql.NullString, error) {
+	var createdBy sql.NullString
+	query := `
+		UPDATE invite_codes SET uses = uses + 1
+		WHERE code = $1 AND revoked_at IS NULL AND uses < max_uses AND (expires_at IS NULL OR expires_at > $2)
+		RETURNING created_by
+	`
+	err := tx.QueryRow(query, code, time.Now()).Scan(&createdBy)
+	if err == sql.ErrNoRows {
+		return createdBy, errInvalidInvite
+	}
+	return createdBy, err
+}
+
+// usedInviteQuotaQuery counts a user's own codes that still count against
+// the quota: used ones and ones that can still be used
+const usedInviteQuotaQuery = `
+	SELECT COUNT(*) FROM invite_codes
+	WHERE created_by = $1 AND batch_id IS NULL
+		AND (uses > 0 OR (revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())))
+`
+
+func (s *Service) usedInviteQuota(userID string) int {
+	var used int
+	s.db.QueryRow(usedInviteQuotaQuery, userID).Scan(&used)
+	return used
+}
+
+func (s *Service) listInvites(where string, limit int, args ...interface{}) ([]Invite, error) {
+	query := `
+		SELECT code, max_uses, uses, expires_at, revoked_at IS NOT NULL, batch_id, created_by, note, created_at
+		FROM invite_codes
+	` + where + `
+		ORDER BY created_at DESC
+		LIMIT ` + strconv.Itoa(limit)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []Invite{}
+	for rows.Next() {
+		var invite Invite
+		var expiresAt sql.NullTime
+		var batchID, createdBy, note sql.NullString
+		err := rows.Scan(&invite.Code, &invite.MaxUses, &invite.Uses, &expiresAt, &invite.Revoked, &batchID, &createdBy, &note, &invite.CreatedAt)
+		if err != nil {
+			continue
+		}
+		invite.Code = formatInviteCode(invite.Code)
+		if expiresAt.Valid {
+			invite.ExpiresAt = &expiresAt.Time
+		}
+		invite.BatchID = batchID.String
+		invite.CreatedBy = createdBy.String
+		invite.Note = note.String
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// newInviteCode returns a random code in its stored form, without the
+// separator shown to users
+func newInviteCode() (string, error) {
+	raw, err := crypto.GenerateRandomBytes(10)
+	if err != nil {
+		return "", err
+	}
+	return normalizeInviteCode(formatRecoveryCode(raw)), nil
+}
+
+// formatInviteCode renders a stored code as xxxxx-xxxxx
+func formatInviteCode(code string) string {
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
+
+// normalizeInviteCode accepts codes typed with or without the separator and
+// in any case
+func normalizeInviteCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snaptalker/backend/internal/security"
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+const (
+	loginAlertPurpose = "login-alert"
+	// loginAlertTTL is how long the "this wasn't me" link in a new device
+	// alert works
+	loginAlertTTL = 7 * 24 * time.Hour
+	// knownDeviceTTL forgets devices that were not used to log in for this
+	// long, so logging in from them alerts again
+	knownDeviceTTL = 180 * 24 * time.Hour
+)
+
+// ReportLoginRequest carries the token from a new device alert
+type ReportLoginRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// loginDevice is what identifies the device a login came from
+type loginDevice struct {
+	Fingerprint string
+	UserAgent   string
+	IPAddress   string
+	Network     string
+}
+
+// fingerprintLogin identifies the device of a login by its user agent and
+// approximate network, so the same device moving between addresses of one
+// provider is still recognised
+func fingerprintLogin(c *gin.Context) loginDevice {
+	device := loginDevice{
+		UserAgent: strings.TrimSpace(c.Request.UserAgent()),
+		IPAddress: c.ClientIP(),
+	}
+	device.Network = approximateNetwork(device.IPAddress)
+	device.Fingerprint = crypto.HashString(device.UserAgent + "\n" + device.Network)
+	return device
+}
+
+// approximateNetwork returns the /24 of an IPv4 or the /48 of an IPv6 address
+func approximateNetwork(ipAddress string) string {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return ipAddress
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// checkLoginDevice remembers the device of a completed login and, when the
+// account has logged in before but never from this device, emails an alert
+// with a link that signs the new session out
+func (s *Service) checkLoginDevice(c *gin.Context, userID, sessionID, deviceName, platform string) {
+	device := fingerprintLogin(c)
+	now := time.Now()
+
+	query := `UPDATE known_devices SET ip_address = $1, last_seen = $2 WHERE user_id = $3 AND fingerprint = $4`
+	result, err := s.db.Exec(query, device.IPAddress, now, userID, device.Fingerprint)
+	if err != nil {
+		log.Printf("Failed to check login device of %s: %v", userID, err)
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		return
+	}
+
+	// The very first login after registering is not worth an alert
+	var seenBefore bool
+	s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM known_devices WHERE user_id = $1)`, userID).Scan(&seenBefore)
+
+	query = `
+		INSERT INTO known_devices (user_id, fingerprint, user_agent, ip_address, network, first_seen, last_seen)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		ON CONFLICT (user_id, fingerprint) DO NOTHING
+	`
+	result, err = s.db.Exec(query, userID, device.Fingerprint, device.UserAgent, device.IPAddress, device.Network, now)
+	if err != nil {
+		log.Printf("Failed to remember login device of %s: %v", userID, err)
+		return
+	}
+	// A concurrent login from the same device already alerted
+	if rows, _ := result.RowsAffected(); rows == 0 || !seenBefore {
+		return
+	}
+
+	s.securityEvent(c, userID, security.NewDeviceLogin, map[string]interface{}{
+		"sessionId": sessionID,
+		"network":   device.Network,
+	})
+
+	address, ok := s.verifiedEmail(userID)
+	if !ok {
+		return
+	}
+	description := device.UserAgent
+	if deviceName != "" {
+		description = deviceName
+		if platform != "" {
+			description += " (" + platform + ")"
+		}
+	}
+	if description == "" {
+		description = "an unknown device"
+	}
+
+	expiresAt := now.Add(loginAlertTTL)
+	token := crypto.SignFields(s.config.LinkSecret, loginAlertPurpose, userID, sessionID, device.Fingerprint, strconv.FormatInt(expiresAt.Unix(), 10))
+	link := strings.TrimSuffix(s.config.PublicURL, "/") + "/secure-account?token=" + url.QueryEscape(token)
+
+	go func() {
+		if err := s.emailService.SendNewDeviceLogin(address, description, device.IPAddress, now, link); err != nil {
+			log.Printf("Failed to send new device alert to %s: %v", userID, err)
+		}
+	}()
+}
+
+// ReportLogin handles the "this wasn't me" link of a new device alert: the
+// session of that login is revoked, the device is forgotten and the password
+// must be reset before it can be used to log in again. The link works once:
+// when the session is already revoked nothing else happens, so opening an old
+// alert after the password was reset does not lock the account again.
+func (s *Service) ReportLogin(c *gin.Context) {
+	var req ReportLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fields, err := crypto.VerifyFields(s.config.LinkSecret, loginAlertPurpose, req.Token)
+	if err != nil || len(fields) != 4 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid link"})
+		return
+	}
+	userID, sessionID, fingerprint := fields[0], fields[1], fields[2]
+	expiresAt, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "link has expired, reset your password from the app"})
+		return
+	}
+
+	revoked, err := s.revokeActiveSession(c, userID, sessionID, "login_not_recognized")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign out the device"})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusOK, gin.H{"message": "the device is already signed out"})
+		return
+	}
+	s.db.Exec(`DELETE FROM known_devices WHERE user_id = $1 AND fingerprint = $2`, userID, fingerprint)
+
+	query := `UPDATE users SET password_reset_required = TRUE, updated_at = $1 WHERE id = $2`
+	if _, err := s.db.Exec(query, time.Now(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to secure account"})
+		return
+	}
+	s.securityEvent(c, userID, security.LoginReported, map[string]interface{}{"sessionId": sessionID})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":               "the device was signed out, reset your password to log in again",
+		"passwordResetRequired": true,
+	})
+}
+
+// purgeKnownDevices forgets devices that have not logged in for a long time
+func (s *Service) purgeKnownDevices() {
+	s.db.Exec(`DELETE FROM known_devices WHERE last_seen < $1`, time.Now().Add(-knownDeviceTTL))
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snaptalker/backend/internal/security"
+	"github.com/snaptalker/backend/pkg/oidc"
+)
+
+// oidcStateTTL is how long a started single sign-on may take to complete
+const oidcStateTTL = 10 * time.Minute
+
+// OIDCStartRequest starts a single sign-on with an identity provider
+type OIDCStartRequest struct {
+	DeviceName string `json:"deviceName"`
+	Platform   string `json:"platform"`
+}
+
+// OIDCCallbackRequest carries the parameters the identity provider
+// redirected back with
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// GetOIDCProviders lists the identity providers users can sign in with
+func (s *Service) GetOIDCProviders(c *gin.Context) {
+	providers := make([]gin.H, 0, len(s.oidcProviders))
+	for _, provider := range s.oidcProviders {
+		config := provider.Config()
+		displayName := config.DisplayName
+		if displayName == "" {
+			displayName = config.Name
+		}
+		providers = append(providers, gin.H{"name": config.Name, "displayName": displayName})
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i]["name"].(string) < providers[j]["name"].(string)
+	})
+	c.JSON(http.StatusOK, gin.H{"providers": providers})
+}
+
+// StartOIDCLogin begins an authorization code flow with PKCE. The client
+// opens the returned URL in a browser and posts the code and state the
+// provider redirects back with to the callback endpoint.
+func (s *Service) StartOIDCLogin(c *gin.Context) {
+	provider, ok := s.oidcProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown identity provider"})
+		return
+	}
+	var req OIDCStartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limits := []limitCheck{{oidcStartPolicy, c.ClientIP()}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	state, err := oidc.NewState()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+	nonce, err := oidc.NewState()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("Failed to start sign-in with %s: %v", provider.Config().Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider is not available"})
+		return
+	}
+
+	// A started flow counts as a failed attempt until its callback succeeds,
+	// so unfinished flows cannot fill the state table. The budget is its own
+	// and generous: organisations often share one egress IP, and abandoned
+	// sign-ins must not block password login there.
+	s.recordFailure(c, limits...)
+	s.db.Exec(`DELETE FROM oidc_login_states WHERE created_at < $1`, time.Now().Add(-oidcStateTTL))
+	query := `
+		INSERT INTO oidc_login_states (state, provider, code_verifier, nonce, device_name, platform, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	if _, err := s.db.Exec(query, state, provider.Config().Name, verifier, nonce, req.DeviceName, req.Platform, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"authorizationUrl": authURL,
+		"state":            state,
+		"expiresIn":        int(oidcStateTTL.Seconds()),
+	})
+}
+
+// CompleteOIDCLogin redeems the authorization code, validates the ID token
+// and signs in the linked account. An identity that is not linked yet is
+// linked to the account whose verified email address matches the verified
+// email address from the provider.
+func (s *Service) CompleteOIDCLogin(c *gin.Context) {
+	provider, ok := s.oidcProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown identity provider"})
+		return
+	}
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limits := []limitCheck{{loginIPPolicy, c.ClientIP()}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	// The state is single-use and bound to the provider it was issued for
+	var verifier, nonce string
+	var deviceName, platform sql.NullString
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state = $1 AND provider = $2 AND created_at > $3
+		RETURNING code_verifier, nonce, device_name, platform
+	`
+	err := s.db.QueryRow(query, req.State, provider.Config().Name, time.Now().Add(-oidcStateTTL)).Scan(&verifier, &nonce, &deviceName, &platform)
+	if err != nil {
+		s.recordFailure(c, limits...)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired sign-in state"})
+		return
+	}
+
+	idToken, err := provider.Exchange(c.Request.Context(), req.Code, verifier, nonce)
+	if err != nil {
+		log.Printf("Sign-in with %s failed: %v", provider.Config().Name, err)
+		s.recordFailure(c, limits...)
+		s.securityEvent(c, "", security.LoginFailed, map[string]interface{}{"method": "oidc", "provider": provider.Config().Name})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "sign-in with the identity provider failed"})
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(idToken.Email))
+	if !idToken.EmailVerified || email == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "the identity provider did not confirm your email address"})
+		return
+	}
+	if !provider.EmailAllowed(email) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "this email domain may not sign in with " + provider.Config().Name})
+		return
+	}
+
+	var user User
+	var totpEnabled bool
+	query = `
+		SELECT u.id, u.username, u.phone, u.email, u.identity_key, u.status, u.created_at, u.totp_enabled
+		FROM user_identities i
+		JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = $1 AND i.subject = $2
+	`
+	err = s.db.QueryRow(query, idToken.Issuer, idToken.Subject).Scan(
+		&user.ID, &user.Username, &user.Phone, &user.Email, &user.IdentityKey, &user.Status, &user.CreatedAt, &totpEnabled,
+	)
+	if err == sql.ErrNoRows {
+		if !s.linkOIDCIdentity(c, idToken, email, &user, &totpEnabled) {
+			return
+		}
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	s.recordSuccess(c, append(limits, limitCheck{oidcStartPolicy, c.ClientIP()})...)
+
+	query = `UPDATE user_identities SET email = $1, last_login_at = $2 WHERE issuer = $3 AND subject = $4`
+	s.db.Exec(query, email, time.Now(), idToken.Issuer, idToken.Subject)
+
+	s.completeLogin(c, &user, totpEnabled, deviceName.String, platform.String)
+}
+
+// linkOIDCIdentity links a new provider identity to the account with the
+// same verified email address and loads that account into user
+func (s *Service) linkOIDCIdentity(c *gin.Context, idToken *oidc.IDToken, email string, user *User, totpEnabled *bool) bool {
+	// users.email is only unique as written, so addresses differing in case
+	// may belong to different accounts; never guess which one to link
+	query := `
+		SELECT id, username, phone, email, identity_key, status, created_at, totp_enabled
+		FROM users
+		WHERE LOWER(email) = $1 AND email_verified = TRUE
+		LIMIT 2
+	`
+	rows, err := s.db.Query(query, email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return false
+	}
+	defer rows.Close()
+	matches := 0
+	for rows.Next() {
+		if err := rows.Scan(
+			&user.ID, &user.Username, &user.Phone, &user.Email, &user.IdentityKey, &user.Status, &user.CreatedAt, totpEnabled,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return false
+		}
+		matches++
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return false
+	}
+	if matches == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "no account with this verified email address, sign in with your phone number and verify your email address first",
+		})
+		return false
+	}
+	if matches > 1 {
+		log.Printf("Refusing to link %s identity %s: %s matches several accounts", idToken.Issuer, idToken.Subject, email)
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "several accounts use this email address, sign in with your phone number instead",
+		})
+		return false
+	}
+	user.EmailVerified = true
+
+	query = `
+		INSERT INTO user_identities (issuer, subject, user_id, email, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (issuer, subject) DO NOTHING
+	`
+	if _, err := s.db.Exec(query, idToken.Issuer, idToken.Subject, user.ID, email, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to link identity"})
+		return false
+	}
+	log.Printf("Linked %s identity %s to user %s", idToken.Issuer, idToken.Subject, user.ID)
+	return true
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// OTPPurpose binds a one-time code to the flow it was issued for, so a code
+// sent for registration cannot be used to reset a password
+type OTPPurpose string
+
+const (
+	OTPPurposeRegister    OTPPurpose = "register"
+	OTPPurposeReset       OTPPurpose = "reset"
+	OTPPurposeLogin       OTPPurpose = "login"
+	OTPPurposeChangePhone OTPPurpose = "change_phone" // sent to both the old and the new number
+	OTPPurposeEmailLogin  OTPPurpose = "email_login"  // passwordless login code
+	OTPPurposeEmailLink   OTPPurpose = "email_link"   // secret of a passwordless login link
+)
+
+// otpTTL is how long a code of each purpose stays valid
+var otpTTL = map[OTPPurpose]time.Duration{
+	OTPPurposeRegister:    10 * time.Minute,
+	OTPPurposeReset:       time.Hour,
+	OTPPurposeLogin:       10 * time.Minute,
+	OTPPurposeChangePhone: 10 * time.Minute,
+	OTPPurposeEmailLogin:  10 * time.Minute,
+	OTPPurposeEmailLink:   10 * time.Minute,
+}
+
+// OTPRecipient identifies where a one-time code is delivered. Senders use
+// whichever field matches their channel.
+type OTPRecipient struct {
+	Phone string
+	Email string
+}
+
+// issueOTP generates a code for the identifier and purpose, stores its hash
+// (replacing any outstanding code) and hands it to the configured OTPSender
+func (s *Service) issueOTP(ctx context.Context, identifier string, recipient OTPRecipient, purpose OTPPurpose) error {
+	code, err := s.generateOTP()
+	if err != nil {
+		return err
+	}
+	if err := s.storeOTP(identifier, purpose, code); err != nil {
+		return err
+	}
+	return s.otpSender.Send(ctx, recipient, code, purpose, otpTTL[purpose])
+}
+
+// storeOTP stores the hash of a code, replacing any outstanding code for the
+// identifier and purpose
+func (s *Service) storeOTP(identifier string, purpose OTPPurpose, code string) error {
+	codeHash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only the latest code is valid
+	query := `UPDATE otp_codes SET consumed_at = $1 WHERE identifier = $2 AND purpose = $3 AND consumed_at IS NULL`
+	if _, err := tx.Exec(query, time.Now(), identifier, string(purpose)); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO otp_codes (id, identifier, purpose, code_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	now := time.Now()
+	if _, err := tx.Exec(query, uuid.New().String(), identifier, string(purpose), string(codeHash), now, now.Add(otpTTL[purpose])); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// consumeOTP checks a code against the outstanding code for the identifier
+// and purpose and marks it used on success
+func (s *Service) consumeOTP(identifier string, purpose OTPPurpose, code string) (bool, error) {
+	var id, codeHash string
+	query := `
+		SELECT id, code_hash FROM otp_codes
+		WHERE identifier = $1 AND purpose = $2 AND consumed_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	err := s.db.QueryRow(query, identifier, string(purpose)).Scan(&id, &codeHash)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(codeHash), []byte(code)) != nil {
+		return false, nil
+	}
+
+	// Guard against the same code being redeemed twice concurrently
+	result, err := s.db.Exec(`UPDATE otp_codes SET consumed_at = $1 WHERE id = $2 AND consumed_at IS NULL`, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// generateOTP returns a uniformly random 6-digit code
+func (s *Service) generateOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/snaptalker/backend/internal/email"
+)
+
+// OTPSender delivers one-time codes to users
+type OTPSender interface {
+	Send(ctx context.Context, recipient OTPRecipient, code string, purpose OTPPurpose, validity time.Duration) error
+}
+
+// OTPConfig selects and configures the OTP delivery provider
+type OTPConfig struct {
+	Provider      string // sms, email or log
+	SMSGatewayURL string
+	SMSAPIKey     string
+	SMSSenderID   string
+	LogFile       string // log provider only; empty logs to stdout
+}
+
+// NewOTPSender creates the OTPSender selected by config
+func NewOTPSender(config OTPConfig, emailService *email.Service) (OTPSender, error) {
+	switch config.Provider {
+	case "sms":
+		if config.SMSGatewayURL == "" {
+			return nil, fmt.Errorf("SMS gateway URL not configured")
+		}
+		return &SMSGatewaySender{
+			url:      config.SMSGatewayURL,
+			apiKey:   config.SMSAPIKey,
+			senderID: config.SMSSenderID,
+			client:   &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	case "email":
+		return &EmailOTPSender{email: emailService}, nil
+	case "log", "":
+		return &LogOTPSender{path: config.LogFile}, nil
+	default:
+		return nil, fmt.Errorf("unknown OTP provider %q", config.Provider)
+	}
+}
+
+// SMSGatewaySender posts codes to an HTTP SMS gateway as JSON
+type SMSGatewaySender struct {
+	url      string
+	apiKey   string
+	senderID string
+	client   *http.Client
+}
+
+// Send sends the code by SMS
+func (g *SMSGatewaySender) Send(ctx context.Context, recipient OTPRecipient, code string, purpose OTPPurpose, validity time.Duration) error {
+	if recipient.Phone == "" {
+		return fmt.Errorf("recipient has no phone number")
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"to":      recipient.Phone,
+		"from":    g.senderID,
+		"message": otpMessage(code, purpose, validity),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+g.apiKey)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach SMS gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("SMS gateway returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// EmailOTPSender sends codes through the email service
+type EmailOTPSender struct {
+	email *email.Service
+}
+
+// Send sends the code by email
+func (e *EmailOTPSender) Send(ctx context.Context, recipient OTPRecipient, code string, purpose OTPPurpose, validity time.Duration) error {
+	if recipient.Email == "" {
+		return fmt.Errorf("recipient has no email address")
+	}
+
+	subject, heading, intro := "SnapTalker - Verification Code", "Verify Your Phone Number", "Use this code to finish setting up SnapTalker."
+	switch purpose {
+	case OTPPurposeReset:
+		subject, heading, intro = "SnapTalker - Password Reset OTP", "Password Reset Request", "We received a request to reset your password."
+	case OTPPurposeLogin:
+		subject, heading, intro = "SnapTalker - Login Code", "Your Login Code", "Use this code to sign in to SnapTalker."
+	case OTPPurposeChangePhone:
+		subject, heading, intro = "SnapTalker - Phone Number Change", "Confirm Your Phone Number Change", "We received a request to move your SnapTalker account to a new phone number."
+	}
+
+	return e.email.SendCode(recipient.Email, subject, heading, intro, code, formatValidity(validity))
+}
+
+// LogOTPSender writes codes to a local file or the server log. Intended for
+// development only.
+type LogOTPSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Send logs the code
+func (l *LogOTPSender) Send(ctx context.Context, recipient OTPRecipient, code string, purpose OTPPurpose, validity time.Duration) error {
+	line := fmt.Sprintf("%s purpose=%s phone=%s email=%s code=%s\n",
+		time.Now().Format(time.RFC3339), purpose, recipient.Phone, recipient.Email, code)
+
+	if l.path == "" {
+		log.Print("OTP " + line)
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(line)
+	return err
+}
+
+func otpMessage(code string, purpose OTPPurpose, validity time.Duration) string {
+	action := "verify your SnapTalker account"
+	switch purpose {
+	case OTPPurposeReset:
+		action = "reset your SnapTalker password"
+	case OTPPurposeLogin:
+		action = "sign in to SnapTalker"
+	case OTPPurposeChangePhone:
+		action = "change your SnapTalker phone number"
+	}
+	return fmt.Sprintf("%s is your code to %s. It expires in %s. Do not share it with anyone.", code, action, formatValidity(validity))
+}
+
+// formatValidity renders a duration as "10 minutes" or "1 hour"
+func formatValidity(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	}
+	minutes := int(d.Round(time.Minute) / time.Minute)
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
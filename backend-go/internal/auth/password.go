@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snaptalker/backend/internal/security"
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+// DefaultMinPasswordLength is the shortest password accepted unless the
+// password policy is configured otherwise
+const DefaultMinPasswordLength = 8
+
+// MaxPasswordLength bounds the input to the password hash
+const MaxPasswordLength = 128
+
+// ChangePasswordRequest represents an authenticated password change
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+// ChangePassword changes the current user's password after verifying the
+// current one, then signs out every other session
+func (s *Service) ChangePassword(c *gin.Context) {
+	userID := c.GetString("userId")
+	sessionID := c.GetString("sessionId")
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limits := []limitCheck{{reauthPolicy, userID}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	if !s.checkPassword(userID, req.CurrentPassword) {
+		s.recordFailure(c, limits...)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "current password is incorrect"})
+		return
+	}
+	s.recordSuccess(c, limits...)
+
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "new password must be different from the current password"})
+		return
+	}
+
+	if s.rejectWeakPassword(c, req.NewPassword) {
+		return
+	}
+
+	passwordHash, err := s.config.PasswordHasher.Hash(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+
+	query := `UPDATE users SET password_hash = $1, password_reset_required = FALSE, updated_at = $2 WHERE id = $3`
+	_, err = s.db.Exec(query, passwordHash, time.Now(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change password"})
+		return
+	}
+
+	// Sign out every other device; their refresh tokens die with the sessions
+	revoked, err := s.revokeAllSessions(c, userID, sessionID, "password_changed")
+	if err != nil {
+		log.Printf("Failed to revoke sessions after password change for %s: %v", userID, err)
+	}
+
+	s.securityEvent(c, userID, security.PasswordChanged, nil)
+	s.notifyPasswordChanged(userID, c.ClientIP())
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "password changed successfully",
+		"revokedSessions": revoked,
+	})
+}
+
+// notifyPasswordChanged emails the user that their password was changed
+func (s *Service) notifyPasswordChanged(userID, ipAddress string) {
+	email, ok := s.verifiedEmail(userID)
+	if !ok {
+		return
+	}
+
+	go func() {
+		if err := s.emailService.SendPasswordChanged(email, ipAddress, time.Now()); err != nil {
+			log.Printf("Failed to send password change notice to %s: %v", userID, err)
+		}
+	}()
+}
+
+// rejectWeakPassword writes a 400 response when password violates the
+// password policy
+func (s *Service) rejectWeakPassword(c *gin.Context, password string) bool {
+	err := s.config.PasswordPolicy.Check(password)
+	if err == nil {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":    err.Error(),
+		"breached": errors.Is(err, crypto.ErrPasswordBreached),
+	})
+	return true
+}
+
+// passwordMatches verifies password against the user's stored hash. After a
+// successful check a hash with an outdated algorithm or parameters is
+// replaced, so accounts move to the current hasher as their owners log in.
+func (s *Service) passwordMatches(userID, password, passwordHash string) bool {
+	ok, err := s.config.PasswordHasher.Verify(password, passwordHash)
+	if err != nil {
+		log.Printf("Failed to verify password hash of %s: %v", userID, err)
+	}
+	if !ok {
+		return false
+	}
+
+	if s.config.PasswordHasher.NeedsRehash(passwordHash) {
+		newHash, err := s.config.PasswordHasher.Hash(password)
+		if err != nil {
+			log.Printf("Failed to rehash password of %s: %v", userID, err)
+			return true
+		}
+		// Only replace the hash that was verified, never a concurrent change
+		query := `UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3`
+		if _, err := s.db.Exec(query, newHash, userID, passwordHash); err != nil {
+			log.Printf("Failed to store rehashed password of %s: %v", userID, err)
+		}
+	}
+	return true
+}
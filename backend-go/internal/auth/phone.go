@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snaptalker/backend/internal/security"
+)
+
+// ChangePhoneRequest starts a phone number change
+type ChangePhoneRequest struct {
+	NewPhone string `json:"newPhone" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// VerifyPhoneChangeRequest carries the codes sent to the old and new numbers
+type VerifyPhoneChangeRequest struct {
+	OldCode string `json:"oldCode" binding:"required"`
+	NewCode string `json:"newCode" binding:"required"`
+}
+
+// RequestPhoneChange starts moving the current account to a new phone number.
+// A code is sent to both the current and the new number.
+func (s *Service) RequestPhoneChange(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req ChangePhoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limits := []limitCheck{{reauthPolicy, userID}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	if !s.checkPassword(userID, req.Password) {
+		s.recordFailure(c, limits...)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+		return
+	}
+	s.recordSuccess(c, limits...)
+
+	var oldPhone string
+	if err := s.db.QueryRow(`SELECT phone FROM users WHERE id = $1`, userID).Scan(&oldPhone); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	email, _ := s.verifiedEmail(userID)
+	if req.NewPhone == oldPhone {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "new phone number is the same as the current one"})
+		return
+	}
+
+	var exists bool
+	s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE phone = $1)`, req.NewPhone).Scan(&exists)
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "phone number already registered"})
+		return
+	}
+
+	query := `
+		INSERT INTO pending_phone_changes (user_id, new_phone, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET new_phone = EXCLUDED.new_phone, created_at = EXCLUDED.created_at
+	`
+	if _, err := s.db.Exec(query, userID, req.NewPhone, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start phone number change"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := s.issueOTP(ctx, oldPhone, OTPRecipient{Phone: oldPhone, Email: email}, OTPPurposeChangePhone); err != nil {
+		log.Printf("Failed to send phone change OTP to %s: %v", oldPhone, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification code"})
+		return
+	}
+	if err := s.issueOTP(ctx, req.NewPhone, OTPRecipient{Phone: req.NewPhone}, OTPPurposeChangePhone); err != nil {
+		log.Printf("Failed to send phone change OTP to %s: %v", req.NewPhone, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification code"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "verification codes sent to your current and new phone numbers",
+		"newPhone": req.NewPhone,
+	})
+}
+
+// VerifyPhoneChange completes a phone number change once both numbers are
+// verified. The user ID, keys, sessions and conversations are kept.
+func (s *Service) VerifyPhoneChange(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req VerifyPhoneChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var oldPhone, newPhone string
+	query := `
+		SELECT u.phone, p.new_phone FROM pending_phone_changes p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.user_id = $1 AND p.created_at > $2
+	`
+	err := s.db.QueryRow(query, userID, time.Now().Add(-otpTTL[OTPPurposeChangePhone])).Scan(&oldPhone, &newPhone)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no phone number change pending"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify phone number change"})
+		return
+	}
+
+	limits := []limitCheck{{otpPhonePolicy, oldPhone}, {otpPhonePolicy, newPhone}, {otpIPPolicy, c.ClientIP()}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	// Both codes must be valid; a failure on either invalidates the attempt
+	oldValid, err := s.consumeOTP(oldPhone, OTPPurposeChangePhone, req.OldCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+	newValid, err := s.consumeOTP(newPhone, OTPPurposeChangePhone, req.NewCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+	if !oldValid || !newValid {
+		s.recordFailure(c, limits...)
+		s.securityEvent(c, userID, security.OTPFailed, map[string]interface{}{"purpose": string(OTPPurposeChangePhone)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired code"})
+		return
+	}
+	s.recordSuccess(c, limits[0], limits[1])
+	s.securityEvent(c, userID, security.OTPVerified, map[string]interface{}{"purpose": string(OTPPurposeChangePhone)})
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change phone number"})
+		return
+	}
+	defer tx.Rollback()
+
+	var taken bool
+	tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE phone = $1)`, newPhone).Scan(&taken)
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "phone number already registered"})
+		return
+	}
+
+	query = `UPDATE users SET phone = $1, phone_hash = $2, updated_at = $3 WHERE id = $4 AND phone = $5`
+	result, err := tx.Exec(query, newPhone, PhoneHash(newPhone), time.Now(), userID, oldPhone)
+	if err != nil {
+		// Lost a race against a registration of the same number
+		c.JSON(http.StatusConflict, gin.H{"error": "phone number already registered"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows != 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "phone number changed concurrently"})
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM pending_phone_changes WHERE user_id = $1`, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change phone number"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change phone number"})
+		return
+	}
+
+	s.notifyContacts(userID, map[string]interface{}{
+		"type":   "phone_number_changed",
+		"userId": userID,
+		"phone":  newPhone,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "phone number changed successfully",
+		"phone":   newPhone,
+	})
+}
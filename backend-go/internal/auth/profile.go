@@ -0,0 +1,359 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/snaptalker/backend/internal/privacy"
+	"github.com/snaptalker/backend/pkg/imaging"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxAboutLength       = 140
+	maxAvatarUploadSize  = 5 << 20
+	avatarURLTTL         = 24 * time.Hour
+	avatarJPEGQuality    = 85
+	// maxConcurrentAvatars bounds how many uploads are decoded at once; each
+	// can take 4 bytes per pixel up to imaging.MaxPixels, plus its crop
+	maxConcurrentAvatars = 2
+)
+
+// avatarSlots is a semaphore around decoding and resizing avatars
+var avatarSlots = make(chan struct{}, maxConcurrentAvatars)
+
+// avatarSizes are the square sizes every profile photo is stored in; the
+// first is the full-size avatar, the last the thumbnail
+var avatarSizes = []int{640, 96}
+
+// UpdateProfileRequest is a partial profile update; omitted fields are left
+// unchanged and an empty display name or about text clears it
+type UpdateProfileRequest struct {
+	Username    *string `json:"username" binding:"omitempty,min=3,max=50"`
+	Email       *string `json:"email" binding:"omitempty,email"`
+	DisplayName *string `json:"displayName"`
+	About       *string `json:"about"`
+}
+
+// UpdateProfile applies a partial update to the current user's profile and
+// pushes the new profile to their contacts
+func (s *Service) UpdateProfile(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if utf8.RuneCountInString(username) < 3 || hasControlChars(username) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid username"})
+			return
+		}
+		if s.profileFieldTaken(c, "username", username, userID) {
+			return
+		}
+		set("username", username)
+	}
+	// A new email address only replaces the current one once confirmed
+	var pendingEmail string
+	if req.Email != nil {
+		email := strings.ToLower(strings.TrimSpace(*req.Email))
+		var current string
+		s.db.QueryRow(`SELECT email FROM users WHERE id = $1`, userID).Scan(&current)
+		if email == current {
+			set("pending_email", nil)
+		} else {
+			if s.profileFieldTaken(c, "email", email, userID) {
+				return
+			}
+			pendingEmail = email
+			set("pending_email", email)
+		}
+	}
+	if req.DisplayName != nil {
+		displayName, err := profileText(*req.DisplayName, maxDisplayNameLength, "display name")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		set("display_name", displayName)
+	}
+	if req.About != nil {
+		about, err := profileText(*req.About, maxAboutLength, "about")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		set("about", about)
+	}
+
+	if len(sets) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no profile fields to update"})
+		return
+	}
+
+	set("updated_at", time.Now())
+	args = append(args, userID)
+	query := fmt.Sprintf(`UPDATE users SET %s WHERE id = $%d`, strings.Join(sets, ", "), len(args))
+	if _, err := s.db.Exec(query, args...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update profile"})
+		return
+	}
+
+	if pendingEmail != "" {
+		go s.sendEmailVerification(userID, pendingEmail)
+	}
+
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load profile"})
+		return
+	}
+	s.withAvatarURLs(c.Request.Context(), user)
+	s.notifyProfileUpdated(user)
+
+	message := "profile updated successfully"
+	if pendingEmail != "" {
+		message = "profile updated successfully, confirm your new email address from the link we sent to it"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"user":    user,
+	})
+}
+
+// UploadAvatar stores a new profile photo. The image is center-cropped and
+// resized to every avatar size and re-encoded as JPEG, which also strips
+// any metadata from the upload.
+func (s *Service) UploadAvatar(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	if s.minio == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "media storage is not available"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAvatarUploadSize+1<<20)
+	file, _, err := c.Request.FormFile("avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "avatar file required (max 5 MB)"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarUploadSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read avatar"})
+		return
+	}
+	if len(data) > maxAvatarUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "avatar must be at most 5 MB"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	select {
+	case avatarSlots <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	images, err := renderAvatar(data)
+	<-avatarSlots
+	if err != nil {
+		message := "invalid image"
+		if errors.Is(err, imaging.ErrUnsupportedFormat) {
+			message = "avatar must be a JPEG, PNG or GIF image"
+		} else if errors.Is(err, imaging.ErrTooLarge) {
+			message = "avatar dimensions are too large"
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	avatarKey := fmt.Sprintf("avatars/%s/%s", userID, uuid.New().String())
+	for i, size := range avatarSizes {
+		objectName := avatarObject(avatarKey, size)
+		if _, err := s.minio.UploadStream(ctx, objectName, bytes.NewReader(images[i]), int64(len(images[i])), "image/jpeg"); err != nil {
+			log.Printf("Failed to upload avatar for %s: %v", userID, err)
+			s.minio.DeletePrefix(ctx, avatarKey+"/")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store avatar"})
+			return
+		}
+	}
+
+	var oldKey sql.NullString
+	s.db.QueryRow(`SELECT avatar_key FROM users WHERE id = $1`, userID).Scan(&oldKey)
+
+	query := `UPDATE users SET avatar_key = $1, updated_at = $2 WHERE id = $3`
+	if _, err := s.db.Exec(query, avatarKey, time.Now(), userID); err != nil {
+		s.minio.DeletePrefix(ctx, avatarKey+"/")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update avatar"})
+		return
+	}
+	if oldKey.Valid && oldKey.String != "" {
+		s.minio.DeletePrefix(ctx, oldKey.String+"/")
+	}
+
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load profile"})
+		return
+	}
+	s.withAvatarURLs(ctx, user)
+	s.notifyProfileUpdated(user)
+
+	c.JSON(http.StatusOK, gin.H{
+		"avatarUrl":      user.AvatarURL,
+		"avatarThumbUrl": user.AvatarThumbURL,
+	})
+}
+
+// renderAvatar decodes an upload and encodes it as JPEG at every avatar size
+func renderAvatar(data []byte) ([][]byte, error) {
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	images := make([][]byte, len(avatarSizes))
+	for i, size := range avatarSizes {
+		var buf bytes.Buffer
+		if err := imaging.EncodeJPEG(&buf, imaging.SquareThumbnail(img, size), avatarJPEGQuality); err != nil {
+			return nil, err
+		}
+		images[i] = buf.Bytes()
+	}
+	return images, nil
+}
+
+// DeleteAvatar removes the current user's profile photo
+func (s *Service) DeleteAvatar(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var oldKey sql.NullString
+	s.db.QueryRow(`SELECT avatar_key FROM users WHERE id = $1`, userID).Scan(&oldKey)
+
+	query := `UPDATE users SET avatar_key = NULL, updated_at = $1 WHERE id = $2`
+	if _, err := s.db.Exec(query, time.Now(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove avatar"})
+		return
+	}
+	if oldKey.Valid && oldKey.String != "" && s.minio != nil {
+		s.minio.DeletePrefix(c.Request.Context(), oldKey.String+"/")
+	}
+
+	if user, err := s.getUserByID(userID); err == nil {
+		s.notifyProfileUpdated(user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "avatar removed"})
+}
+
+// withAvatarURLs fills in presigned avatar URLs for a user with a photo
+func (s *Service) withAvatarURLs(ctx context.Context, user *User) {
+	if user.avatarKey == "" || s.minio == nil {
+		return
+	}
+	expiry := int(avatarURLTTL.Seconds())
+	if url, err := s.minio.GetPresignedURL(ctx, avatarObject(user.avatarKey, avatarSizes[0]), expiry); err == nil {
+		user.AvatarURL = url
+	}
+	if url, err := s.minio.GetPresignedURL(ctx, avatarObject(user.avatarKey, avatarSizes[len(avatarSizes)-1]), expiry); err == nil {
+		user.AvatarThumbURL = url
+	}
+}
+
+// notifyProfileUpdated pushes the public part of a profile to contacts, as
+// far as each contact may see it
+func (s *Service) notifyProfileUpdated(user *User) {
+	for _, hook := range s.contactEventHooks {
+		hook(user.ID, func(recipientID string) map[string]interface{} {
+			return map[string]interface{}{
+				"type":    "profile_updated",
+				"userId":  user.ID,
+				"profile": s.publicProfile(user, recipientID),
+			}
+		})
+	}
+}
+
+// visibleProfile returns a copy of user without the fields viewerID may not
+// see under the user's privacy settings
+func (s *Service) visibleProfile(user *User, viewerID string) *User {
+	visible := *user
+	if s.privacy == nil {
+		return &visible
+	}
+	if !s.privacy.CanSee(user.ID, viewerID, privacy.ProfilePhoto) {
+		visible.AvatarURL = ""
+		visible.AvatarThumbURL = ""
+	}
+	if !s.privacy.CanSee(user.ID, viewerID, privacy.About) {
+		visible.About = ""
+	}
+	return &visible
+}
+
+// profileFieldTaken writes a 409 response when another user already uses value
+func (s *Service) profileFieldTaken(c *gin.Context, column, value, userID string) bool {
+	var taken bool
+	query := fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM users WHERE %s = $1 AND id != $2)`, column)
+	if err := s.db.QueryRow(query, value, userID).Scan(&taken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return true
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": column + " already in use"})
+		return true
+	}
+	return false
+}
+
+// profileText trims and validates free-text profile fields; an empty result
+// is stored as NULL
+func profileText(value string, maxLength int, field string) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if !utf8.ValidString(value) || hasControlChars(value) {
+		return nil, fmt.Errorf("%s contains invalid characters", field)
+	}
+	if utf8.RuneCountInString(value) > maxLength {
+		return nil, fmt.Errorf("%s must be at most %d characters", field, maxLength)
+	}
+	return value, nil
+}
+
+func hasControlChars(value string) bool {
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func avatarObject(avatarKey string, size int) string {
+	return fmt.Sprintf("%s/%d.jpg", avatarKey, size)
+}
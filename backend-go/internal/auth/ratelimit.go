@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snaptalker/backend/pkg/storage"
+)
+
+// attemptPolicy describes how many failures are tolerated before lockout and
+// how the lockout grows with every further failure
+type attemptPolicy struct {
+	Scope       string
+	MaxFailures int64
+	Window      time.Duration // failures older than this are forgotten
+	BaseLockout time.Duration // first lockout, doubled on every further failure
+	MaxLockout  time.Duration
+}
+
+var (
+	loginPhonePolicy = attemptPolicy{"login:phone", 5, time.Hour, 30 * time.Second, time.Hour}
+	loginIPPolicy    = attemptPolicy{"login:ip", 20, time.Hour, 30 * time.Second, time.Hour}
+	loginEmailPolicy = attemptPolicy{"login:email", 5, time.Hour, 30 * time.Second, time.Hour}
+	otpPhonePolicy   = attemptPolicy{"otp:phone", 5, time.Hour, time.Minute, 6 * time.Hour}
+	otpIPPolicy      = attemptPolicy{"otp:ip", 20, time.Hour, time.Minute, 6 * time.Hour}
+	resetPhonePolicy = attemptPolicy{"reset:phone", 5, time.Hour, time.Minute, 6 * time.Hour}
+	resetIPPolicy    = attemptPolicy{"reset:ip", 20, time.Hour, time.Minute, 6 * time.Hour}
+	twoFactorPolicy  = attemptPolicy{"2fa:user", 5, time.Hour, 30 * time.Second, time.Hour}
+	reauthPolicy     = attemptPolicy{"password:user", 5, time.Hour, 30 * time.Second, time.Hour}
+	regLockPolicy    = attemptPolicy{"reglock:phone", 5, 24 * time.Hour, time.Hour, 7 * 24 * time.Hour}
+	inviteIPPolicy   = attemptPolicy{"invite:ip", 10, time.Hour, time.Minute, 6 * time.Hour}
+	oidcStartPolicy  = attemptPolicy{"oidc-start:ip", 100, time.Hour, time.Minute, time.Hour}
+)
+
+// limitCheck pairs a policy with the key (phone, IP, user ID) it applies to
+type limitCheck struct {
+	policy attemptPolicy
+	key    string
+}
+
+// attemptLimiter counts failed attempts per key and locks keys out with
+// exponential backoff. Counters live in Redis when configured and in process
+// memory otherwise, or while Redis cannot be reached, so limits never fail
+// open.
+type attemptLimiter struct {
+	redis *storage.RedisClient
+
+	mu    sync.Mutex
+	local map[string]localCounter
+}
+
+type localCounter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+func newAttemptLimiter(redis *storage.RedisClient) *attemptLimiter {
+	return &attemptLimiter{
+		redis: redis,
+		local: make(map[string]localCounter),
+	}
+}
+
+// Locked reports whether the key is locked out and for how long
+func (l *attemptLimiter) Locked(ctx context.Context, policy attemptPolicy, key string) (time.Duration, bool) {
+	lockKey := fmt.Sprintf("ratelimit:%s:%s:lock", policy.Scope, key)
+
+	if l.redis != nil {
+		ttl, err := l.redis.TTL(ctx, lockKey)
+		if err == nil && ttl > 0 {
+			return ttl, true
+		}
+		// Lockouts recorded in memory during a Redis outage still apply
+		if err != nil {
+			log.Printf("Rate limiter falling back to memory: %v", err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	counter, ok := l.local[lockKey]
+	if !ok || time.Now().After(counter.expiresAt) {
+		delete(l.local, lockKey)
+		return 0, false
+	}
+	return time.Until(counter.expiresAt), true
+}
+
+// Fail records a failed attempt and locks the key out once the policy's
+// failure budget is exhausted
+func (l *attemptLimiter) Fail(ctx context.Context, policy attemptPolicy, key string) {
+	failKey := fmt.Sprintf("ratelimit:%s:%s:fails", policy.Scope, key)
+	lockKey := fmt.Sprintf("ratelimit:%s:%s:lock", policy.Scope, key)
+
+	failures := l.incr(ctx, failKey, policy.Window)
+	if failures < policy.MaxFailures {
+		return
+	}
+
+	lockout := policy.lockout(failures)
+	if l.redis != nil {
+		err := l.redis.Set(ctx, lockKey, failures, lockout)
+		if err == nil {
+			return
+		}
+		log.Printf("Rate limiter falling back to memory: %v", err)
+	}
+
+	l.mu.Lock()
+	l.local[lockKey] = localCounter{value: failures, expiresAt: time.Now().Add(lockout)}
+	l.mu.Unlock()
+}
+
+// Reset clears the failure count of a key after a successful attempt
+func (l *attemptLimiter) Reset(ctx context.Context, policy attemptPolicy, key string) {
+	failKey := fmt.Sprintf("ratelimit:%s:%s:fails", policy.Scope, key)
+
+	if l.redis != nil {
+		l.redis.Delete(ctx, failKey)
+	}
+
+	l.mu.Lock()
+	delete(l.local, failKey)
+	l.mu.Unlock()
+}
+
+// Consume adds n to a usage quota and reports whether the quota still covers
+// it. Usage is counted even when the quota is exceeded.
+func (l *attemptLimiter) Consume(ctx context.Context, key string, n, quota int64, window time.Duration) (int64, bool) {
+	used := l.incrBy(ctx, fmt.Sprintf("quota:%s", key), n, window)
+	return used, used <= quota
+}
+
+func (l *attemptLimiter) incr(ctx context.Context, key string, window time.Duration) int64 {
+	return l.incrBy(ctx, key, 1, window)
+}
+
+func (l *attemptLimiter) incrBy(ctx context.Context, key string, n int64, window time.Duration) int64 {
+	if l.redis != nil {
+		count, err := l.redis.IncrBy(ctx, key, n)
+		if err == nil {
+			l.redis.Expire(ctx, key, window)
+			return count
+		}
+		log.Printf("Rate limiter falling back to memory: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	// Opportunistically drop expired counters so the map cannot grow unbounded
+	if len(l.local) > 10000 {
+		for k, v := range l.local {
+			if now.After(v.expiresAt) {
+				delete(l.local, k)
+			}
+		}
+	}
+
+	counter := l.local[key]
+	if now.After(counter.expiresAt) {
+		counter.value = 0
+	}
+	counter.value += n
+	counter.expiresAt = now.Add(window)
+	l.local[key] = counter
+	return counter.value
+}
+
+// lockout returns BaseLockout doubled for every failure past MaxFailures,
+// capped at MaxLockout
+func (p attemptPolicy) lockout(failures int64) time.Duration {
+	exponent := float64(failures - p.MaxFailures)
+	lockout := time.Duration(float64(p.BaseLockout) * math.Pow(2, exponent))
+	if lockout <= 0 || lockout > p.MaxLockout {
+		return p.MaxLockout
+	}
+	return lockout
+}
+
+// rejectIfLocked writes a 429 response with Retry-After when any of the given
+// policy/key pairs is locked out
+func (s *Service) rejectIfLocked(c *gin.Context, checks ...limitCheck) bool {
+	for _, check := range checks {
+		if retryAfter, locked := s.limiter.Locked(c.Request.Context(), check.policy, check.key); locked {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":      "too many attempts, try again later",
+				"retryAfter": seconds,
+			})
+			return true
+		}
+	}
+	return false
+}
+
+// recordFailure counts a failed attempt against every given policy/key pair
+func (s *Service) recordFailure(c *gin.Context, checks ...limitCheck) {
+	for _, check := range checks {
+		s.limiter.Fail(c.Request.Context(), check.policy, check.key)
+	}
+}
+
+// recordSuccess clears the failure counts of the given policy/key pairs
+func (s *Service) recordSuccess(c *gin.Context, checks ...limitCheck) {
+	for _, check := range checks {
+		s.limiter.Reset(c.Request.Context(), check.policy, check.key)
+	}
+}
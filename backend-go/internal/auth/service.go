@@ -1,34 +1,99 @@
 package auth
 
 import (
-	"crypto/rand"
+	"database/sql"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/snaptalker/backend/internal/email"
+	"github.com/snaptalker/backend/internal/privacy"
+	"github.com/snaptalker/backend/internal/security"
+	"github.com/snaptalker/backend/pkg/crypto"
+	"github.com/snaptalker/backend/pkg/oidc"
 	"github.com/snaptalker/backend/pkg/storage"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Service handles authentication and authorization
 type Service struct {
-	db           *storage.PostgresDB
-	redis        *storage.RedisClient
-	jwtSecret    []byte
-	emailService *email.Service
+	db                   *storage.PostgresDB
+	redis                *storage.RedisClient
+	minio                *storage.MinIOClient
+	config               Config
+	signingKeys          *crypto.KeyRing
+	emailService         *email.Service
+	otpSender            OTPSender
+	limiter              *attemptLimiter
+	privacy              *privacy.Service
+	oidcProviders        map[string]*oidc.Provider
+	sessionRevokedHooks  []func(userID, sessionID string)
+	userEventHooks       []func(userID string, event map[string]interface{})
+	contactEventHooks    []func(userID string, event func(recipientID string) map[string]interface{})
+	accountDisabledHooks []func(userID string)
+}
+
+// Config holds auth service settings
+type Config struct {
+	// SigningKeys signs and verifies access and challenge tokens
+	SigningKeys *crypto.KeyRing
+	// DeletionGracePeriod is how long a deleted account can still be
+	// restored before its data is purged; zero purges immediately
+	DeletionGracePeriod time.Duration
+	// Privacy decides which profile and presence fields other users may see
+	Privacy *privacy.Service
+	// LinkSecret signs shareable links such as profile links
+	LinkSecret []byte
+	// PublicURL is the web app base URL that shared links point to
+	PublicURL string
+	// PasswordHasher hashes new passwords; defaults to argon2id with
+	// crypto.DefaultArgon2Params
+	PasswordHasher *crypto.PasswordHasher
+	// PasswordPolicy decides which new passwords are accepted
+	PasswordPolicy *crypto.PasswordPolicy
+	// OIDCProviders are the identity providers allowed for single sign-on
+	OIDCProviders []*oidc.Provider
+	// SecurityLog records logins, password and session changes; optional
+	SecurityLog *security.Log
+	// RegistrationMode is RegistrationOpen (the default), RegistrationInvite
+	// or RegistrationClosed
+	RegistrationMode string
+	// InviteQuota is how many invite codes each user may create
+	InviteQuota int
 }
 
 // NewService creates a new auth service
-func NewService(db *storage.PostgresDB, redis *storage.RedisClient, jwtSecret string) *Service {
+func NewService(db *storage.PostgresDB, redis *storage.RedisClient, minio *storage.MinIOClient, otpSender OTPSender, emailService *email.Service, config Config) *Service {
+	if config.PasswordHasher == nil {
+		config.PasswordHasher, _ = crypto.NewPasswordHasher(crypto.DefaultArgon2Params)
+	}
+	if config.PasswordPolicy == nil {
+		config.PasswordPolicy = &crypto.PasswordPolicy{MinLength: DefaultMinPasswordLength, MaxLength: MaxPasswordLength}
+	}
+	if config.RegistrationMode == "" {
+		config.RegistrationMode = RegistrationOpen
+	}
+	oidcProviders := make(map[string]*oidc.Provider, len(config.OIDCProviders))
+	for _, provider := range config.OIDCProviders {
+		oidcProviders[provider.Config().Name] = provider
+	}
 	return &Service{
 		db:           db,
 		redis:        redis,
-		jwtSecret:    []byte(jwtSecret),
-		emailService: email.NewService(),
+		minio:        minio,
+		config:       config,
+		signingKeys:  config.SigningKeys,
+		emailService: emailService,
+		otpSender:    otpSender,
+		limiter:      newAttemptLimiter(redis),
+		privacy:      config.Privacy,
+
+		oidcProviders: oidcProviders,
 	}
 }
 
@@ -37,14 +102,21 @@ type RegisterRequest struct {
 	Username    string `json:"username" binding:"required,min=3,max=50"`
 	Phone       string `json:"phone" binding:"required"`
 	Email       string `json:"email" binding:"required,email"`
-	Password    string `json:"password" binding:"required,min=8"`
+	Password    string `json:"password" binding:"required"`
 	IdentityKey string `json:"identityKey" binding:"required"`
+	// RegistrationLockPIN is required when re-registering a phone number
+	// whose account has a registration lock
+	RegistrationLockPIN string `json:"registrationLockPin"`
+	// InviteCode is required in invite-only mode and optional otherwise
+	InviteCode string `json:"inviteCode"`
 }
 
 // LoginRequest represents a login request
 type LoginRequest struct {
-	Phone    string `json:"phone" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Phone      string `json:"phone" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	DeviceName string `json:"deviceName"`
+	Platform   string `json:"platform"`
 }
 
 // User represents a user
@@ -54,7 +126,21 @@ type User struct {
 	Phone       string    `json:"phone"`
 	Email       string    `json:"email"`
 	IdentityKey string    `json:"identityKey"`
+	Status      string    `json:"status"`
 	CreatedAt   time.Time `json:"createdAt"`
+
+	// EmailVerified is set once the email address was confirmed by link;
+	// PendingEmail is a requested new address awaiting confirmation
+	EmailVerified bool   `json:"emailVerified"`
+	PendingEmail  string `json:"pendingEmail,omitempty"`
+
+	Handle         string `json:"handle,omitempty"`
+	DisplayName    string `json:"displayName,omitempty"`
+	About          string `json:"about,omitempty"`
+	AvatarURL      string `json:"avatarUrl,omitempty"`
+	AvatarThumbURL string `json:"avatarThumbUrl,omitempty"`
+
+	avatarKey string // MinIO prefix of the current avatar
 }
 
 // Register handles user registration
@@ -64,11 +150,36 @@ func (s *Service) Register(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if s.rejectWeakPassword(c, req.Password) {
+		return
+	}
+
+	// Registering an existing phone number moves the account to a new device
+	var existingID string
+	err := s.db.QueryRow(`SELECT id FROM users WHERE phone = $1`, req.Phone).Scan(&existingID)
+	if err == nil {
+		s.reregister(c, existingID, req)
+		return
+	}
+
+	inviteCode := normalizeInviteCode(req.InviteCode)
+	switch {
+	case s.config.RegistrationMode == RegistrationClosed:
+		c.JSON(http.StatusForbidden, gin.H{"error": "registration is closed"})
+		return
+	case s.config.RegistrationMode == RegistrationInvite && inviteCode == "":
+		c.JSON(http.StatusForbidden, gin.H{"error": "an invite code is required to register", "inviteRequired": true})
+		return
+	}
+	limits := []limitCheck{{inviteIPPolicy, c.ClientIP()}}
+	if inviteCode != "" && s.rejectIfLocked(c, limits...) {
+		return
+	}
 
 	// Check if user already exists
 	var exists bool
 	query := `SELECT EXISTS(SELECT 1 FROM users WHERE phone = $1 OR email = $2)`
-	err := s.db.QueryRow(query, req.Phone, req.Email).Scan(&exists)
+	err = s.db.QueryRow(query, req.Phone, req.Email).Scan(&exists)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 		return
@@ -79,7 +190,7 @@ func (s *Service) Register(c *gin.Context) {
 	}
 
 	// Hash password
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	passwordHash, err := s.config.PasswordHasher.Hash(req.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
 		return
@@ -88,33 +199,53 @@ func (s *Service) Register(c *gin.Context) {
 	// Generate user ID
 	userID := uuid.New().String()
 
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
+		return
+	}
+	defer tx.Rollback()
+
+	// The invite is used up together with creating the account
+	var invitedBy sql.NullString
+	if inviteCode != "" {
+		invitedBy, err = redeemInvite(tx, inviteCode)
+		if err == errInvalidInvite {
+			s.recordFailure(c, limits...)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
+			return
+		}
+	}
+
 	// Create user (identity key will be set when uploading key bundle)
 	query = `
-		INSERT INTO users (id, username, phone, email, password_hash, identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, 0, '', '', $7)
+		INSERT INTO users (id, username, phone, phone_hash, email, password_hash, identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature, status, created_at, invited_by, invite_code)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 0, '', '', $8, $9, $10, NULLIF($11, ''))
 	`
-	_, err = s.db.Exec(query, userID, req.Username, req.Phone, req.Email, passwordHash, req.IdentityKey, time.Now())
+	_, err = tx.Exec(query, userID, req.Username, req.Phone, PhoneHash(req.Phone), req.Email, passwordHash, req.IdentityKey, AccountPending, time.Now(), invitedBy, inviteCode)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
 		return
 	}
-
-	// Generate OTP for phone verification (only if Redis is available)
-	var otp string
-	if s.redis != nil {
-		otp = s.generateOTP()
-		s.redis.Set(c.Request.Context(), fmt.Sprintf("otp:%s", req.Phone), otp, 10*time.Minute)
-	} else {
-		// Skip OTP verification if Redis not available
-		otp = "000000" // Placeholder for development
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
+		return
 	}
 
-	// TODO: Send OTP via SMS
+	// Send OTP for phone verification
+	recipient := OTPRecipient{Phone: req.Phone, Email: req.Email}
+	if err := s.issueOTP(c.Request.Context(), req.Phone, recipient, OTPPurposeRegister); err != nil {
+		log.Printf("Failed to send registration OTP to %s: %v", req.Phone, err)
+	}
+	go s.sendEmailVerification(userID, req.Email)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"userId":  userID,
 		"message": "user registered successfully, please verify OTP",
-		"otp":     otp, // Remove in production
 	})
 }
 
@@ -126,41 +257,99 @@ func (s *Service) Login(c *gin.Context) {
 		return
 	}
 
+	limits := []limitCheck{{loginPhonePolicy, req.Phone}, {loginIPPolicy, c.ClientIP()}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
 	// Get user from database
 	var user User
 	var passwordHash string
+	var totpEnabled bool
 	query := `
-		SELECT id, username, phone, email, password_hash, identity_key, created_at
+		SELECT id, username, phone, email, password_hash, identity_key, status, created_at, totp_enabled
 		FROM users
 		WHERE phone = $1
 	`
 	err := s.db.QueryRow(query, req.Phone).Scan(
-		&user.ID, &user.Username, &user.Phone, &user.Email, &passwordHash, &user.IdentityKey, &user.CreatedAt,
+		&user.ID, &user.Username, &user.Phone, &user.Email, &passwordHash, &user.IdentityKey, &user.Status, &user.CreatedAt, &totpEnabled,
 	)
 	if err != nil {
+		s.recordFailure(c, limits...)
+		s.securityEvent(c, "", security.LoginFailed, map[string]interface{}{"method": "password"})
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
 	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+	if !s.passwordMatches(user.ID, req.Password, passwordHash) {
+		s.recordFailure(c, limits...)
+		s.securityEvent(c, user.ID, security.LoginFailed, map[string]interface{}{"method": "password"})
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
+	s.recordSuccess(c, limits[0])
 
-	// Generate JWT token
-	token, err := s.generateToken(user.ID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+	s.completeLogin(c, &user, totpEnabled, req.DeviceName, req.Platform)
+}
+
+// completeLogin finishes a login once the first factor (password or email
+// login code) has been verified: it checks the account status, asks for the
+// second factor when enabled and otherwise issues the token pair
+func (s *Service) completeLogin(c *gin.Context, user *User, totpEnabled bool, deviceName, platform string) {
+	if !s.loginAllowed(c, user) {
+		return
+	}
+
+	// Two-step verification: hand out a short-lived challenge instead of tokens
+	if totpEnabled {
+		challengeToken, err := s.generateChallengeToken(user.ID, deviceName, platform)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate challenge"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"twoFactorRequired": true,
+			"challengeToken":    challengeToken,
+		})
 		return
 	}
 
-	// Generate refresh token
-	refreshToken, err := s.generateRefreshToken(user.ID)
+	s.finishLogin(c, user, deviceName, platform)
+}
+
+// loginAllowed rejects logins to accounts that are not active or whose owner
+// reported a login they did not recognise. It runs right before tokens are
+// issued, so a login that started before a ban cannot finish after it.
+func (s *Service) loginAllowed(c *gin.Context, user *User) bool {
+	user.Status = s.liftExpiredSuspension(user.ID, user.Status)
+	if user.Status != AccountActive {
+		code, message := accountStatusError(user.Status)
+		c.JSON(code, gin.H{"error": message, "status": user.Status})
+		return false
+	}
+	var resetRequired bool
+	err := s.db.QueryRow(`SELECT password_reset_required FROM users WHERE id = $1`, user.ID).Scan(&resetRequired)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return false
+	}
+	if resetRequired {
+		c.JSON(http.StatusForbidden, gin.H{"error": "password reset required", "passwordResetRequired": true})
+		return false
+	}
+	return true
+}
+
+// finishLogin creates a session for a user who passed every login check and
+// responds with the token pair bound to it
+func (s *Service) finishLogin(c *gin.Context, user *User, deviceName, platform string) {
+	token, refreshToken, sessionID, err := s.issueTokens(c, user.ID, deviceName, platform)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate refresh token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
+	s.checkLoginDevice(c, user.ID, sessionID, deviceName, platform)
 
 	c.JSON(http.StatusOK, gin.H{
 		"token":        token,
@@ -180,31 +369,49 @@ func (s *Service) VerifyOTP(c *gin.Context) {
 		return
 	}
 
-	// Get OTP from Redis (skip if Redis not available)
-	if s.redis == nil {
-		// No Redis, skip OTP verification
-		c.JSON(http.StatusOK, gin.H{"message": "OTP verification skipped (development mode)"})
+	limits := []limitCheck{{otpPhonePolicy, req.Phone}, {otpIPPolicy, c.ClientIP()}}
+	if s.rejectIfLocked(c, limits...) {
 		return
 	}
 
-	storedOTP, err := s.redis.Get(c.Request.Context(), fmt.Sprintf("otp:%s", req.Phone))
+	// Verify and consume the registration OTP
+	valid, err := s.consumeOTP(req.Phone, OTPPurposeRegister, req.OTP)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify OTP"})
+		return
+	}
+	var userID string
+	s.db.QueryRow(`SELECT id FROM users WHERE phone = $1`, req.Phone).Scan(&userID)
+	if !valid {
+		s.recordFailure(c, limits...)
+		s.securityEvent(c, userID, security.OTPFailed, map[string]interface{}{"purpose": string(OTPPurposeRegister)})
 		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP expired or invalid"})
 		return
 	}
+	s.recordSuccess(c, limits[0])
+	s.securityEvent(c, userID, security.OTPVerified, map[string]interface{}{"purpose": string(OTPPurposeRegister)})
 
-	if storedOTP != req.OTP {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid OTP"})
+	// Activate the account now that the phone number is verified
+	if err := s.activateAccount(req.Phone); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to activate account"})
 		return
 	}
 
-	// Delete OTP from Redis
-	s.redis.Delete(c.Request.Context(), fmt.Sprintf("otp:%s", req.Phone))
+	// Complete a pending re-registration of this phone number, if any
+	completed, err := s.completeReregistration(c, req.Phone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete re-registration"})
+		return
+	}
+	if completed {
+		c.JSON(http.StatusOK, gin.H{"message": "OTP verified successfully, account moved to this device"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "OTP verified successfully"})
 }
 
-// RefreshToken generates a new access token from a refresh token
+// RefreshToken rotates a refresh token and issues a new access token
 func (s *Service) RefreshToken(c *gin.Context) {
 	var req struct {
 		RefreshToken string `json:"refreshToken" binding:"required"`
@@ -214,21 +421,28 @@ func (s *Service) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	// Verify refresh token
-	userID, err := s.verifyRefreshToken(req.RefreshToken)
+	// Rotate refresh token (revokes the session on reuse)
+	userID, sessionID, refreshToken, err := s.rotateRefreshToken(c, req.RefreshToken)
+	if err == ErrPasswordResetRequired {
+		c.JSON(http.StatusForbidden, gin.H{"error": "password reset required", "passwordResetRequired": true})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
 		return
 	}
 
 	// Generate new access token
-	token, err := s.generateToken(userID)
+	token, err := s.generateToken(userID, sessionID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, gin.H{
+		"token":        token,
+		"refreshToken": refreshToken,
+	})
 }
 
 // AuthMiddleware validates JWT tokens
@@ -247,33 +461,57 @@ func (s *Service) AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// Parse token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return s.jwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := s.parseToken(tokenString)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
 
-		// Extract user ID from claims
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			if userID, ok := claims["userId"].(string); ok {
-				c.Set("userId", userID)
+		// Extract user ID and session ID from claims
+		userID, hasUser := claims["userId"].(string)
+		sessionID, hasSession := claims["sid"].(string)
+		if hasUser && hasSession {
+			// Reject tokens whose session was revoked or whose account is not active
+			active, accountStatus, deviceID, resetRequired, err := s.sessionState(sessionID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify session"})
+				c.Abort()
+				return
+			}
+			if !active {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+				c.Abort()
+				return
+			}
+			accountStatus = s.liftExpiredSuspension(userID, accountStatus)
+			if accountStatus != AccountActive {
+				code, message := accountStatusError(accountStatus)
+				c.JSON(code, gin.H{"error": message, "status": accountStatus})
+				c.Abort()
+				return
+			}
+			if resetRequired {
+				c.JSON(http.StatusForbidden, gin.H{"error": "password reset required", "passwordResetRequired": true})
+				c.Abort()
+				return
+			}
 
-				// Set user context for Row-Level Security
-				if err := s.db.SetUserContext(c.Request.Context(), userID); err != nil {
-					// Log error but don't block request - RLS policies will handle authorization
-					fmt.Printf("Warning: Failed to set RLS user context: %v\n", err)
-				}
+			c.Set("userId", userID)
+			c.Set("sessionId", sessionID)
+			c.Set("deviceId", deviceID)
+			if role, ok := claims["role"].(string); ok {
+				c.Set("role", role)
+			}
 
-				c.Next()
-				return
+			// Set user context for Row-Level Security
+			if err := s.db.SetUserContext(c.Request.Context(), userID); err != nil {
+				// Log error but don't block request - RLS policies will handle authorization
+				fmt.Printf("Warning: Failed to set RLS user context: %v\n", err)
 			}
+
+			c.Next()
+			return
 		}
 
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
@@ -289,110 +527,71 @@ func (s *Service) GetCurrentUser(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
+	s.withAvatarURLs(c.Request.Context(), user)
 	c.JSON(http.StatusOK, user)
 }
 
-// UpdateProfile updates the user's profile
-func (s *Service) UpdateProfile(c *gin.Context) {
-	userID := c.GetString("userId")
-	var req struct {
-		Username string `json:"username"`
-		Email    string `json:"email"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	query := `UPDATE users SET username = $1, email = $2, updated_at = $3 WHERE id = $4`
-	_, err := s.db.Exec(query, req.Username, req.Email, time.Now(), userID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update profile"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "profile updated successfully"})
-}
-
 // GetUserProfile returns a user's public profile
 func (s *Service) GetUserProfile(c *gin.Context) {
 	userID := c.Param("userId")
 	user, err := s.getUserByID(userID)
-	if err != nil {
+	// Pending, suspended, banned and deleted accounts have no public profile
+	if err != nil || s.liftExpiredSuspension(user.ID, user.Status) != AccountActive {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
-	c.JSON(http.StatusOK, user)
+	s.withAvatarURLs(c.Request.Context(), user)
+	c.JSON(http.StatusOK, s.publicProfile(user, c.GetString("userId")))
 }
 
 // Helper functions
 
-func (s *Service) generateToken(userID string) (string, error) {
-	claims := jwt.MapClaims{
-		"userId": userID,
-		"exp":    time.Now().Add(24 * time.Hour).Unix(),
-		"iat":    time.Now().Unix(),
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
+// securityEvent records an account event with the request's IP address and
+// user agent; c is nil for events raised outside a request
+func (s *Service) securityEvent(c *gin.Context, userID string, eventType security.EventType, details map[string]interface{}) {
+	s.config.SecurityLog.RecordRequest(c, userID, eventType, details)
 }
 
-func (s *Service) generateRefreshToken(userID string) (string, error) {
+func (s *Service) generateToken(userID, sessionID string) (string, error) {
+	role := RoleUser
+	s.db.QueryRow(`SELECT role FROM users WHERE id = $1`, userID).Scan(&role)
+
 	claims := jwt.MapClaims{
 		"userId": userID,
-		"exp":    time.Now().Add(30 * 24 * time.Hour).Unix(),
+		"sid":    sessionID,
+		"role":   role,
+		"exp":    time.Now().Add(accessTokenTTL).Unix(),
 		"iat":    time.Now().Unix(),
-		"type":   "refresh",
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
-}
-
-func (s *Service) verifyRefreshToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return s.jwtSecret, nil
-	})
-	if err != nil || !token.Valid {
-		return "", fmt.Errorf("invalid token")
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", fmt.Errorf("invalid claims")
-	}
-
-	if tokenType, ok := claims["type"].(string); !ok || tokenType != "refresh" {
-		return "", fmt.Errorf("not a refresh token")
-	}
-
-	userID, ok := claims["userId"].(string)
-	if !ok {
-		return "", fmt.Errorf("invalid userId")
-	}
-
-	return userID, nil
-}
-
-func (s *Service) generateOTP() string {
-	bytes := make([]byte, 3)
-	rand.Read(bytes)
-	return fmt.Sprintf("%06d", int(bytes[0])<<16|int(bytes[1])<<8|int(bytes[2]))[:6]
+	return s.signToken(claims)
 }
 
 func (s *Service) getUserByID(userID string) (*User, error) {
 	var user User
-	query := `SELECT id, username, phone, email, identity_key, created_at FROM users WHERE id = $1`
+	var pendingEmail, handle, displayName, about, avatarKey sql.NullString
+	query := `
+		SELECT id, username, phone, email, email_verified, pending_email, identity_key, status, created_at,
+			handle, display_name, about, avatar_key
+		FROM users WHERE id = $1
+	`
 	err := s.db.QueryRow(query, userID).Scan(
-		&user.ID, &user.Username, &user.Phone, &user.Email, &user.IdentityKey, &user.CreatedAt,
+		&user.ID, &user.Username, &user.Phone, &user.Email, &user.EmailVerified, &pendingEmail, &user.IdentityKey, &user.Status, &user.CreatedAt,
+		&handle, &displayName, &about, &avatarKey,
 	)
 	if err != nil {
 		return nil, err
 	}
+	user.PendingEmail = pendingEmail.String
+	user.Handle = handle.String
+	user.DisplayName = displayName.String
+	user.About = about.String
+	user.avatarKey = avatarKey.String
 	return &user, nil
 }
 
 // GetOnlineStatus returns online status for multiple users
 func (s *Service) GetOnlineStatus(c *gin.Context) {
+	viewerID := c.GetString("userId")
 	userIDs := c.QueryArray("userIds")
 	if len(userIDs) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "userIds required"})
@@ -402,7 +601,10 @@ func (s *Service) GetOnlineStatus(c *gin.Context) {
 	statuses := make(map[string]bool)
 	for _, userID := range userIDs {
 		key := fmt.Sprintf("online:%s", userID)
-		if s.redis != nil {
+		if s.privacy != nil && !s.privacy.CanSee(userID, viewerID, privacy.Online) {
+			// Hidden presence is indistinguishable from being offline
+			statuses[userID] = false
+		} else if s.redis != nil {
 			val, err := s.redis.Get(c.Request.Context(), key)
 			statuses[userID] = err == nil && val == "true"
 		} else {
@@ -443,42 +645,28 @@ func (s *Service) ForgotPassword(c *gin.Context) {
 		return
 	}
 
-	// Check if user exists and get email
-	var userID, email string
-	query := `SELECT id, email FROM users WHERE phone = $1`
-	err := s.db.QueryRow(query, req.Phone).Scan(&userID, &email)
+	// Check if user exists
+	var userID string
+	query := `SELECT id FROM users WHERE phone = $1`
+	err := s.db.QueryRow(query, req.Phone).Scan(&userID)
 	if err != nil {
 		// Don't reveal if user exists or not for security
-		c.JSON(http.StatusOK, gin.H{"message": "If the phone number is registered, a reset OTP will be sent to your email"})
-		return
-	}
-
-	// Generate reset token (6-digit code)
-	resetToken := s.generateOTP()
-
-	// Store reset token in database with expiry (1 hour)
-	updateQuery := `
-		UPDATE users 
-		SET reset_token = $1, reset_token_expiry = NOW() + INTERVAL '1 hour'
-		WHERE id = $2
-	`
-	_, err = s.db.Exec(updateQuery, resetToken, userID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate reset token"})
+		c.JSON(http.StatusOK, gin.H{"message": "If the phone number is registered, a reset OTP has been sent"})
 		return
 	}
 
-	// Send OTP via email
-	err = s.emailService.SendOTP(email, resetToken)
-	if err != nil {
-		// Log error but don't fail the request (for development, OTP is printed to console)
-		fmt.Printf("Failed to send email: %v\n", err)
+	// Generate and send reset code (valid for 1 hour). Codes are never
+	// emailed to an unverified address.
+	email, _ := s.verifiedEmail(userID)
+	recipient := OTPRecipient{Phone: req.Phone, Email: email}
+	if err := s.issueOTP(c.Request.Context(), req.Phone, recipient, OTPPurposeReset); err != nil {
+		// Log error but don't fail the request
+		log.Printf("Failed to send reset OTP to %s: %v", req.Phone, err)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "If the email is registered, a reset OTP has been sent",
-		"email":   email, // Show masked email for confirmation
-	})
+	// Same response as for an unknown number, so it does not reveal whether
+	// the phone is registered
+	c.JSON(http.StatusOK, gin.H{"message": "If the phone number is registered, a reset OTP has been sent"})
 }
 
 // ResetPassword resets user password with token
@@ -486,82 +674,108 @@ func (s *Service) ResetPassword(c *gin.Context) {
 	var req struct {
 		Phone       string `json:"phone" binding:"required"`
 		ResetToken  string `json:"resetToken" binding:"required"`
-		NewPassword string `json:"newPassword" binding:"required,min=8"`
+		NewPassword string `json:"newPassword" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if s.rejectWeakPassword(c, req.NewPassword) {
+		return
+	}
 
-	// Verify reset token
+	limits := []limitCheck{{resetPhonePolicy, req.Phone}, {resetIPPolicy, c.ClientIP()}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	// Verify and consume reset token
 	var userID string
-	query := `
-		SELECT id FROM users 
-		WHERE phone = $1 
-		AND reset_token = $2 
-		AND reset_token_expiry > NOW()
-	`
-	err := s.db.QueryRow(query, req.Phone, req.ResetToken).Scan(&userID)
+	err := s.db.QueryRow(`SELECT id FROM users WHERE phone = $1`, req.Phone).Scan(&userID)
 	if err != nil {
+		s.recordFailure(c, limits...)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
 		return
 	}
 
+	valid, err := s.consumeOTP(req.Phone, OTPPurposeReset, req.ResetToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify reset token"})
+		return
+	}
+	if !valid {
+		s.recordFailure(c, limits...)
+		s.securityEvent(c, userID, security.OTPFailed, map[string]interface{}{"purpose": string(OTPPurposeReset)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+		return
+	}
+	s.recordSuccess(c, limits[0])
+
 	// Hash new password
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	passwordHash, err := s.config.PasswordHasher.Hash(req.NewPassword)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
 		return
 	}
 
-	// Update password and clear reset token
-	updateQuery := `
-		UPDATE users 
-		SET password_hash = $1, reset_token = NULL, reset_token_expiry = NULL
-		WHERE id = $2
-	`
+	// Update password
+	updateQuery := `UPDATE users SET password_hash = $1, password_reset_required = FALSE, updated_at = NOW() WHERE id = $2`
 	_, err = s.db.Exec(updateQuery, passwordHash, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
 		return
 	}
 
+	// Whoever knew the old password must not stay signed in
+	if _, err := s.revokeAllSessions(c, userID, "", "password_reset"); err != nil {
+		log.Printf("Failed to revoke sessions after password reset for %s: %v", userID, err)
+	}
+
+	s.securityEvent(c, userID, security.PasswordReset, nil)
+	s.notifyPasswordChanged(userID, c.ClientIP())
+
 	c.JSON(http.StatusOK, gin.H{"message": "Password reset successful"})
 }
 
-// SearchUsers handles user search by username or phone
+// SearchUsers finds users by username prefix. Phone numbers are not
+// searchable; address book matches go through DiscoverContacts instead.
 func (s *Service) SearchUsers(c *gin.Context) {
-	query := c.Query("q")
-	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "search query required"})
+	userID := c.GetString("userId")
+	query := strings.TrimSpace(c.Query("q"))
+	if utf8.RuneCountInString(query) < minSearchLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("search query must be at least %d characters", minSearchLength)})
 		return
 	}
 
-	// Get current user ID from context
-	userID, _ := c.Get("userId")
-
-	// Search for users by username or phone (excluding current user)
+	// Search for users by username (excluding current user and anyone
+	// blocked in either direction)
 	sqlQuery := `
-		SELECT id, username, phone, email, identity_key, created_at 
+		SELECT id, username, phone, handle, display_name, about, avatar_key
 		FROM users 
-		WHERE (username ILIKE $1 OR phone LIKE $2) AND id != $3
+		WHERE username ILIKE $1 ESCAPE '\' AND id != $2 AND status = 'active'
+		AND NOT EXISTS (
+			SELECT 1 FROM blocked_users b
+			WHERE (b.user_id = $2 AND b.blocked_user_id = users.id) OR (b.user_id = users.id AND b.blocked_user_id = $2)
+		)
+		ORDER BY username
 		LIMIT 20
 	`
 
-	rows, err := s.db.Query(sqlQuery, "%"+query+"%", "%"+query+"%", userID)
+	rows, err := s.db.Query(sqlQuery, likeEscaper.Replace(query)+"%", userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "search failed"})
 		return
 	}
 	defer rows.Close()
 
-	var users []User
+	users := []PublicProfile{}
 	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Phone, &user.Email, &user.IdentityKey, &user.CreatedAt); err != nil {
+		user, err := scanPublicUser(rows)
+		if err != nil {
 			continue
 		}
-		users = append(users, user)
+		s.withAvatarURLs(c.Request.Context(), user)
+		users = append(users, s.publicProfile(user, userID))
 	}
 
 	c.JSON(http.StatusOK, gin.H{
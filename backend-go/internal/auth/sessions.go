@@ -0,0 +1,360 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/snaptalker/backend/internal/security"
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+const (
+	accessTokenTTL  = 24 * time.Hour
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrSessionRevoked     = errors.New("session revoked")
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	ErrAccountInactive    = errors.New("account not active")
+	// ErrPasswordResetRequired is returned for sessions of an account whose
+	// owner reported a login they did not recognise
+	ErrPasswordResetRequired = errors.New("password reset required")
+)
+
+// Session represents a logged-in device. Every refresh token issued to the
+// device belongs to the same session, so revoking the session kills the
+// whole refresh token family.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"userId"`
+	DeviceName string    `json:"deviceName"`
+	Platform   string    `json:"platform"`
+	UserAgent  string    `json:"userAgent"`
+	IPAddress  string    `json:"ipAddress"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+// OnSessionRevoked registers a callback invoked with the user and session ID
+// whenever a session is revoked, so realtime services can drop its connections
+func (s *Service) OnSessionRevoked(fn func(userID, sessionID string)) {
+	s.sessionRevokedHooks = append(s.sessionRevokedHooks, fn)
+}
+
+// OnUserEvent registers a callback that delivers an event to all of a
+// user's own connected devices
+func (s *Service) OnUserEvent(fn func(userID string, event map[string]interface{})) {
+	s.userEventHooks = append(s.userEventHooks, fn)
+}
+
+// Logout revokes the session of the current access token
+func (s *Service) Logout(c *gin.Context) {
+	userID := c.GetString("userId")
+	sessionID := c.GetString("sessionId")
+
+	if err := s.revokeSession(c, userID, sessionID, "logout"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
+}
+
+// LogoutAll revokes every session of the current user, including this one
+func (s *Service) LogoutAll(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	count, err := s.revokeAllSessions(c, userID, "", "logout_all")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "logged out from all devices",
+		"revokedSessions": count,
+	})
+}
+
+// GetSessions lists the current user's active sessions
+func (s *Service) GetSessions(c *gin.Context) {
+	userID := c.GetString("userId")
+	currentSessionID := c.GetString("sessionId")
+
+	query := `
+		SELECT id, user_id, COALESCE(device_name, ''), COALESCE(platform, ''), COALESCE(user_agent, ''),
+		       COALESCE(ip_address, ''), created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get sessions"})
+		return
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.DeviceName, &session.Platform, &session.UserAgent,
+			&session.IPAddress, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+		if err != nil {
+			continue
+		}
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// TerminateSession revokes one of the current user's sessions
+func (s *Service) TerminateSession(c *gin.Context) {
+	userID := c.GetString("userId")
+	sessionID := c.Param("sessionId")
+
+	var ownerID string
+	err := s.db.QueryRow(`SELECT user_id FROM sessions WHERE id = $1 AND revoked_at IS NULL`, sessionID).Scan(&ownerID)
+	if err != nil || ownerID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if err := s.revokeSession(c, userID, sessionID, "terminated"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to terminate session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session terminated"})
+}
+
+// issueTokens creates a new session for the user and returns an access token
+// bound to it together with the session's first refresh token
+func (s *Service) issueTokens(c *gin.Context, userID, deviceName, platform string) (token, refreshToken, sessionID string, err error) {
+	return s.issueDeviceTokens(c, userID, "", deviceName, platform)
+}
+
+// issueDeviceTokens is issueTokens for a session bound to a linked device;
+// an empty deviceID is the primary device
+func (s *Service) issueDeviceTokens(c *gin.Context, userID, deviceID, deviceName, platform string) (token, refreshToken, sessionID string, err error) {
+	sessionID = uuid.New().String()
+	refreshToken, err = crypto.GenerateRandomToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	now := time.Now()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", "", "", err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO sessions (id, user_id, device_id, device_name, platform, user_agent, ip_address, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $8, $9)
+	`
+	_, err = tx.Exec(query, sessionID, userID, deviceID, deviceName, platform, c.Request.UserAgent(), c.ClientIP(), now, now.Add(refreshTokenTTL))
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to create session: %w", err)
+	}
+
+	query = `INSERT INTO refresh_tokens (token_hash, session_id, created_at, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err = tx.Exec(query, crypto.HashString(refreshToken), sessionID, now, now.Add(refreshTokenTTL))
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", "", err
+	}
+	details := map[string]interface{}{"sessionId": sessionID, "deviceName": deviceName, "platform": platform}
+	if deviceID != "" {
+		details["deviceId"] = deviceID
+	}
+	s.securityEvent(c, userID, security.Login, details)
+
+	token, err = s.generateToken(userID, sessionID)
+	if err != nil {
+		return "", "", "", err
+	}
+	return token, refreshToken, sessionID, nil
+}
+
+// rotateRefreshToken exchanges a refresh token for a new one in the same
+// session. Presenting a token that was already rotated is treated as theft:
+// the whole session is revoked and ErrRefreshTokenReused is returned.
+func (s *Service) rotateRefreshToken(c *gin.Context, refreshToken string) (userID, sessionID, newRefreshToken string, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", "", "", err
+	}
+	defer tx.Rollback()
+
+	var usedAt, revokedAt sql.NullTime
+	var expiresAt time.Time
+	var accountStatus string
+	var resetRequired bool
+	tokenHash := crypto.HashString(refreshToken)
+	query := `
+		SELECT s.id, s.user_id, rt.used_at, rt.expires_at, s.revoked_at, u.status, u.password_reset_required
+		FROM refresh_tokens rt
+		JOIN sessions s ON s.id = rt.session_id
+		JOIN users u ON u.id = s.user_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt
+	`
+	err = tx.QueryRow(query, tokenHash).Scan(&sessionID, &userID, &usedAt, &expiresAt, &revokedAt, &accountStatus, &resetRequired)
+	if err == sql.ErrNoRows {
+		return "", "", "", ErrSessionNotFound
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if revokedAt.Valid {
+		return "", "", "", ErrSessionRevoked
+	}
+
+	if usedAt.Valid {
+		tx.Rollback()
+		log.Printf("Refresh token reuse detected for session %s (user %s) from %s", sessionID, userID, c.ClientIP())
+		if err := s.revokeSession(c, userID, sessionID, "refresh_token_reuse"); err != nil {
+			log.Printf("Failed to revoke session %s: %v", sessionID, err)
+		}
+		return "", "", "", ErrRefreshTokenReused
+	}
+
+	if time.Now().After(expiresAt) {
+		return "", "", "", ErrSessionNotFound
+	}
+
+	if s.liftExpiredSuspension(userID, accountStatus) != AccountActive {
+		return "", "", "", ErrAccountInactive
+	}
+	if resetRequired {
+		return "", "", "", ErrPasswordResetRequired
+	}
+
+	newRefreshToken, err = crypto.GenerateRandomToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	now := time.Now()
+	if _, err = tx.Exec(`UPDATE refresh_tokens SET used_at = $1 WHERE token_hash = $2`, now, tokenHash); err != nil {
+		return "", "", "", err
+	}
+
+	query = `INSERT INTO refresh_tokens (token_hash, session_id, created_at, expires_at) VALUES ($1, $2, $3, $4)`
+	if _, err = tx.Exec(query, crypto.HashString(newRefreshToken), sessionID, now, now.Add(refreshTokenTTL)); err != nil {
+		return "", "", "", err
+	}
+
+	query = `UPDATE sessions SET last_used_at = $1, expires_at = $2, ip_address = $3 WHERE id = $4`
+	if _, err = tx.Exec(query, now, now.Add(refreshTokenTTL), c.ClientIP(), sessionID); err != nil {
+		return "", "", "", err
+	}
+	query = `UPDATE devices SET last_seen = $1 WHERE id = (SELECT device_id FROM sessions WHERE id = $2)`
+	if _, err = tx.Exec(query, now, sessionID); err != nil {
+		return "", "", "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", "", err
+	}
+
+	return userID, sessionID, newRefreshToken, nil
+}
+
+// revokeSession revokes a session and every refresh token issued to it
+func (s *Service) revokeSession(c *gin.Context, userID, sessionID, reason string) error {
+	_, err := s.revokeActiveSession(c, userID, sessionID, reason)
+	return err
+}
+
+// revokeActiveSession revokes a session and reports whether it was still
+// active, i.e. whether this call revoked it
+func (s *Service) revokeActiveSession(c *gin.Context, userID, sessionID, reason string) (bool, error) {
+	query := `UPDATE sessions SET revoked_at = $1, revoked_reason = $2 WHERE id = $3 AND revoked_at IS NULL`
+	result, err := s.db.Exec(query, time.Now(), reason, sessionID)
+	if err != nil {
+		return false, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return false, nil
+	}
+	s.securityEvent(c, userID, security.SessionRevoked, map[string]interface{}{"sessionId": sessionID, "reason": reason})
+
+	for _, hook := range s.sessionRevokedHooks {
+		hook(userID, sessionID)
+	}
+	return true, nil
+}
+
+// revokeAllSessions revokes all active sessions of a user except exceptSessionID
+// (pass "" to revoke all of them) and returns how many were revoked
+func (s *Service) revokeAllSessions(c *gin.Context, userID, exceptSessionID, reason string) (int, error) {
+	query := `
+		UPDATE sessions SET revoked_at = $1, revoked_reason = $2
+		WHERE user_id = $3 AND id != $4 AND revoked_at IS NULL
+		RETURNING id
+	`
+	rows, err := s.db.Query(query, time.Now(), reason, userID, exceptSessionID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var sessionIDs []string
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			continue
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+
+	if len(sessionIDs) > 0 {
+		s.securityEvent(c, userID, security.SessionRevoked, map[string]interface{}{"sessionIds": sessionIDs, "reason": reason})
+	}
+	for _, sessionID := range sessionIDs {
+		for _, hook := range s.sessionRevokedHooks {
+			hook(userID, sessionID)
+		}
+	}
+	return len(sessionIDs), rows.Err()
+}
+
+// sessionState reports whether a session exists, is not revoked and has not
+// expired, together with the status of the account it belongs to, the linked
+// device it was issued to (empty for the primary device) and whether the
+// account must reset its password
+func (s *Service) sessionState(sessionID string) (bool, string, string, bool, error) {
+	var active, resetRequired bool
+	var accountStatus string
+	var deviceID sql.NullString
+	query := `
+		SELECT s.revoked_at IS NULL AND s.expires_at > NOW(), u.status, s.device_id, u.password_reset_required
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1
+	`
+	err := s.db.QueryRow(query, sessionID).Scan(&active, &accountStatus, &deviceID, &resetRequired)
+	if err == sql.ErrNoRows {
+		return false, "", "", false, nil
+	}
+	return active, accountStatus, deviceID.String, resetRequired, err
+}
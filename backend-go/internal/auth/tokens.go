@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/snaptalker/backend/pkg/crypto"
+)
+
+// TokenIssuer is the "iss" claim of every token signed by this service
+const TokenIssuer = "snaptalker"
+
+// SigningKeyOverlap is how long a superseded signing key keeps verifying
+// tokens; it covers the lifetime of the longest-lived token
+const SigningKeyOverlap = accessTokenTTL
+
+// JWKS serves the public signing keys so other services can verify tokens
+func (s *Service) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, s.signingKeys.JWKS(time.Now()))
+}
+
+// signToken signs claims with the current signing key and sets its kid
+func (s *Service) signToken(claims jwt.MapClaims) (string, error) {
+	key, err := s.signingKeys.Current(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	method, err := signingMethod(key.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	claims["iss"] = TokenIssuer
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// parseToken verifies a token against the published key named by its kid
+func (s *Service) parseToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.signingKeys.Lookup(kid, time.Now())
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key")
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return key.PublicKey(), nil
+	}, jwt.WithValidMethods([]string{crypto.AlgEdDSA, crypto.AlgES256}), jwt.WithIssuer(TokenIssuer))
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid claims")
+	}
+	return claims, nil
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case crypto.AlgEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	case crypto.AlgES256:
+		return jwt.SigningMethodES256, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
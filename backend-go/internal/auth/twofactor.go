@@ -0,0 +1,580 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/snaptalker/backend/internal/security"
+	"github.com/snaptalker/backend/pkg/crypto"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer         = "SnapTalker"
+	totpSkew           = 1
+	challengeTokenTTL  = 5 * time.Minute
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// TwoFactorLoginRequest completes a login that requires a second factor
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+// SetupTOTP generates a new TOTP secret for the current user. The secret only
+// takes effect once confirmed through EnableTOTP.
+func (s *Service) SetupTOTP(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var phone string
+	var enabled bool
+	err := s.db.QueryRow(`SELECT phone, totp_enabled FROM users WHERE id = $1`, userID).Scan(&phone, &enabled)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-step verification already enabled"})
+		return
+	}
+
+	secret, err := crypto.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+		return
+	}
+
+	_, err = s.db.Exec(`UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2`, secret, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":     secret,
+		"otpauthUri": crypto.TOTPURI(totpIssuer, phone, secret),
+	})
+}
+
+// EnableTOTP confirms TOTP enrollment with a code from the authenticator app
+// and returns a fresh set of single-use recovery codes
+func (s *Service) EnableTOTP(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var secret sql.NullString
+	var enabled bool
+	err := s.db.QueryRow(`SELECT totp_secret, totp_enabled FROM users WHERE id = $1`, userID).Scan(&secret, &enabled)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-step verification already enabled"})
+		return
+	}
+	if !secret.Valid || secret.String == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-step verification setup not started"})
+		return
+	}
+
+	step, ok := crypto.ValidateTOTP(secret.String, req.Code, time.Now(), totpSkew)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return
+	}
+
+	_, err = s.db.Exec(`UPDATE users SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2`, step, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-step verification"})
+		return
+	}
+
+	codes, err := s.generateRecoveryCodes(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "two-step verification enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+// TwoFactorConfirmRequest re-authenticates changes to two-step verification
+// with the password and a current TOTP or recovery code
+type TwoFactorConfirmRequest struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// DisableTOTP turns off two-step verification after re-checking the password
+// and the second factor, so a stolen session plus the password is not enough
+func (s *Service) DisableTOTP(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req TwoFactorConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.confirmTwoFactorChange(c, userID, req) {
+		return
+	}
+
+	_, err := s.db.Exec(`UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0 WHERE id = $1`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-step verification"})
+		return
+	}
+	s.db.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	s.securityEvent(c, userID, security.TwoFactorDisabled, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-step verification disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the current user
+func (s *Service) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req TwoFactorConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.confirmTwoFactorChange(c, userID, req) {
+		return
+	}
+
+	codes, err := s.generateRecoveryCodes(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// confirmTwoFactorChange checks the password and a TOTP or recovery code of
+// an account with two-step verification enabled. Failures count against the
+// re-authentication limit.
+func (s *Service) confirmTwoFactorChange(c *gin.Context, userID string, req TwoFactorConfirmRequest) bool {
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code or recoveryCode required"})
+		return false
+	}
+
+	limits := []limitCheck{{reauthPolicy, userID}}
+	if s.rejectIfLocked(c, limits...) {
+		return false
+	}
+
+	if !s.checkPassword(userID, req.Password) {
+		s.recordFailure(c, limits...)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+		return false
+	}
+
+	var enabled bool
+	s.db.QueryRow(`SELECT totp_enabled FROM users WHERE id = $1`, userID).Scan(&enabled)
+	if !enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-step verification not enabled"})
+		return false
+	}
+
+	if req.Code != "" {
+		if !s.verifyTOTP(userID, req.Code) {
+			s.recordFailure(c, limits...)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return false
+		}
+	} else if !s.consumeRecoveryCode(userID, req.RecoveryCode) {
+		s.recordFailure(c, limits...)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid recovery code"})
+		return false
+	}
+	s.recordSuccess(c, limits...)
+	return true
+}
+
+// LoginTwoFactor exchanges a login challenge token plus a TOTP or recovery
+// code for the usual token pair
+func (s *Service) LoginTwoFactor(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code or recoveryCode required"})
+		return
+	}
+
+	claims, err := s.parseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge"})
+		return
+	}
+	userID, _ := claims["userId"].(string)
+	deviceName, _ := claims["deviceName"].(string)
+	platform, _ := claims["platform"].(string)
+
+	limits := []limitCheck{{twoFactorPolicy, userID}, {loginIPPolicy, c.ClientIP()}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	if req.Code != "" {
+		if !s.verifyTOTP(userID, req.Code) {
+			s.recordFailure(c, limits...)
+			s.securityEvent(c, userID, security.LoginFailed, map[string]interface{}{"method": "totp"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+	} else if !s.consumeRecoveryCode(userID, req.RecoveryCode) {
+		s.recordFailure(c, limits...)
+		s.securityEvent(c, userID, security.LoginFailed, map[string]interface{}{"method": "recovery_code"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid recovery code"})
+		return
+	}
+	s.recordSuccess(c, limits[0])
+
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+	// The account may have been suspended since the challenge was issued
+	if !s.loginAllowed(c, user) {
+		return
+	}
+	s.finishLogin(c, user, deviceName, platform)
+}
+
+// SetRegistrationLock sets or changes the PIN required to re-register the phone number
+func (s *Service) SetRegistrationLock(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req struct {
+		Password string `json:"password" binding:"required"`
+		PIN      string `json:"pin" binding:"required,numeric,min=4,max=8"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limits := []limitCheck{{reauthPolicy, userID}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+	if !s.checkPassword(userID, req.Password) {
+		s.recordFailure(c, limits...)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+		return
+	}
+	s.recordSuccess(c, limits...)
+
+	pinHash, err := bcrypt.GenerateFromPassword([]byte(req.PIN), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash PIN"})
+		return
+	}
+
+	_, err = s.db.Exec(`UPDATE users SET registration_lock_hash = $1 WHERE id = $2`, string(pinHash), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set registration lock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "registration lock enabled"})
+}
+
+// RemoveRegistrationLock removes the registration lock PIN
+func (s *Service) RemoveRegistrationLock(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limits := []limitCheck{{reauthPolicy, userID}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+	if !s.checkPassword(userID, req.Password) {
+		s.recordFailure(c, limits...)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+		return
+	}
+	s.recordSuccess(c, limits...)
+
+	_, err := s.db.Exec(`UPDATE users SET registration_lock_hash = NULL WHERE id = $1`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove registration lock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "registration lock removed"})
+}
+
+// checkRegistrationLock reports whether a re-registration of the account may
+// proceed: true when no lock is set or the PIN matches
+func (s *Service) checkRegistrationLock(userID, pin string) bool {
+	var lockHash sql.NullString
+	err := s.db.QueryRow(`SELECT registration_lock_hash FROM users WHERE id = $1`, userID).Scan(&lockHash)
+	if err != nil {
+		return false
+	}
+	if !lockHash.Valid || lockHash.String == "" {
+		return true
+	}
+	return pin != "" && bcrypt.CompareHashAndPassword([]byte(lockHash.String), []byte(pin)) == nil
+}
+
+// reregister starts moving an existing account to a new device. The new
+// credentials are kept pending until the phone number is verified by OTP,
+// and accounts with a registration lock additionally require the PIN.
+func (s *Service) reregister(c *gin.Context, userID string, req RegisterRequest) {
+	limits := []limitCheck{{regLockPolicy, req.Phone}}
+	if s.rejectIfLocked(c, limits...) {
+		return
+	}
+
+	if !s.checkRegistrationLock(userID, req.RegistrationLockPIN) {
+		if req.RegistrationLockPIN != "" {
+			s.recordFailure(c, limits...)
+		}
+		c.JSON(http.StatusLocked, gin.H{
+			"error":                    "registration lock PIN required",
+			"registrationLockRequired": true,
+		})
+		return
+	}
+
+	passwordHash, err := s.config.PasswordHasher.Hash(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+
+	query := `
+		INSERT INTO pending_reregistrations (phone, user_id, password_hash, identity_key, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (phone) DO UPDATE SET
+			password_hash = EXCLUDED.password_hash,
+			identity_key = EXCLUDED.identity_key,
+			created_at = EXCLUDED.created_at
+	`
+	_, err = s.db.Exec(query, req.Phone, userID, passwordHash, req.IdentityKey, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start re-registration"})
+		return
+	}
+
+	// Phone ownership must be proven before the account changes hands
+	recipient := OTPRecipient{Phone: req.Phone}
+	recipient.Email, _ = s.verifiedEmail(userID)
+	if err := s.issueOTP(c.Request.Context(), req.Phone, recipient, OTPPurposeRegister); err != nil {
+		log.Printf("Failed to send re-registration OTP to %s: %v", req.Phone, err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"userId":  userID,
+		"message": "phone number already registered, verify OTP to move the account to this device",
+	})
+}
+
+// completeReregistration applies a pending re-registration after the phone
+// number was verified: the new password and identity key replace the old ones,
+// old pre-keys are dropped and every existing session is revoked
+func (s *Service) completeReregistration(c *gin.Context, phone string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var userID, passwordHash, identityKey string
+	query := `
+		DELETE FROM pending_reregistrations
+		WHERE phone = $1 AND created_at > NOW() - INTERVAL '10 minutes'
+		RETURNING user_id, password_hash, identity_key
+	`
+	err = tx.QueryRow(query, phone).Scan(&userID, &passwordHash, &identityKey)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	query = `UPDATE users SET password_hash = $1, identity_key = $2, updated_at = $3 WHERE id = $4`
+	if _, err := tx.Exec(query, passwordHash, identityKey, time.Now(), userID); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	// One-time pre-keys belong to the old device's identity key
+	s.db.Exec(`DELETE FROM prekeys WHERE user_id = $1`, userID)
+
+	if s.redis != nil {
+		s.redis.Delete(c.Request.Context(), fmt.Sprintf("keybundle:%s", userID))
+	}
+	// Linked devices were paired with the old primary device
+	s.unlinkAllDevices(userID)
+	if _, err := s.revokeAllSessions(c, userID, "", "reregistered"); err != nil {
+		log.Printf("Failed to revoke sessions after re-registration of %s: %v", userID, err)
+	}
+	s.securityEvent(c, userID, security.IdentityKeyChanged, map[string]interface{}{"reason": "reregistered"})
+	s.notifyContacts(userID, map[string]interface{}{
+		"type":        "identity_key_changed",
+		"userId":      userID,
+		"identityKey": identityKey,
+	})
+	return true, nil
+}
+
+// Helper functions
+
+func (s *Service) checkPassword(userID, password string) bool {
+	var passwordHash string
+	err := s.db.QueryRow(`SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&passwordHash)
+	if err != nil {
+		return false
+	}
+	return s.passwordMatches(userID, password, passwordHash)
+}
+
+// verifyTOTP validates a TOTP code and records its time step so the same code
+// cannot be replayed
+func (s *Service) verifyTOTP(userID, code string) bool {
+	var secret sql.NullString
+	var lastStep int64
+	err := s.db.QueryRow(`SELECT totp_secret, totp_last_step FROM users WHERE id = $1 AND totp_enabled`, userID).Scan(&secret, &lastStep)
+	if err != nil || !secret.Valid {
+		return false
+	}
+
+	step, ok := crypto.ValidateTOTP(secret.String, code, time.Now(), totpSkew)
+	if !ok || step <= lastStep {
+		return false
+	}
+
+	result, err := s.db.Exec(`UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1`, step, userID)
+	if err != nil {
+		return false
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1
+}
+
+func (s *Service) generateRecoveryCodes(userID string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := crypto.GenerateRandomBytes(recoveryCodeLength)
+		if err != nil {
+			return nil, err
+		}
+		code := formatRecoveryCode(raw)
+		query := `INSERT INTO recovery_codes (id, user_id, code_hash, created_at) VALUES ($1, $2, $3, $4)`
+		if _, err := tx.Exec(query, uuid.New().String(), userID, crypto.HashString(normalizeRecoveryCode(code)), time.Now()); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// consumeRecoveryCode marks a matching unused recovery code as used
+func (s *Service) consumeRecoveryCode(userID, code string) bool {
+	query := `
+		UPDATE recovery_codes SET used_at = $1
+		WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL
+	`
+	result, err := s.db.Exec(query, time.Now(), userID, crypto.HashString(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1
+}
+
+// formatRecoveryCode renders random bytes as an xxxxx-xxxxx code using an
+// unambiguous alphabet
+func formatRecoveryCode(raw []byte) string {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	var b strings.Builder
+	for i, v := range raw {
+		if i == len(raw)/2 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(alphabet[int(v)%len(alphabet)])
+	}
+	return b.String()
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func (s *Service) generateChallengeToken(userID, deviceName, platform string) (string, error) {
+	claims := jwt.MapClaims{
+		"userId":     userID,
+		"type":       "2fa_challenge",
+		"deviceName": deviceName,
+		"platform":   platform,
+		"exp":        time.Now().Add(challengeTokenTTL).Unix(),
+		"iat":        time.Now().Unix(),
+	}
+	return s.signToken(claims)
+}
+
+func (s *Service) parseChallengeToken(tokenString string) (jwt.MapClaims, error) {
+	claims, err := s.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if tokenType, ok := claims["type"].(string); !ok || tokenType != "2fa_challenge" {
+		return nil, fmt.Errorf("not a challenge token")
+	}
+	if _, ok := claims["userId"].(string); !ok {
+		return nil, fmt.Errorf("invalid userId")
+	}
+	return claims, nil
+}
@@ -0,0 +1,66 @@
+package calls
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// client is one signaling connection of a user. A user has one per connected
+// device, each opened by its own auth session.
+type client struct {
+	conn      *websocket.Conn
+	sessionID string
+
+	writeMu sync.Mutex // a websocket.Conn supports one concurrent writer
+}
+
+func (c *client) send(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+// clientRegistry tracks the open signaling connections of every user
+type clientRegistry struct {
+	mu     sync.RWMutex
+	byUser map[string]map[*client]struct{}
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{byUser: make(map[string]map[*client]struct{})}
+}
+
+// add registers a connection
+func (r *clientRegistry) add(userID string, c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := r.byUser[userID]
+	if clients == nil {
+		clients = make(map[*client]struct{})
+		r.byUser[userID] = clients
+	}
+	clients[c] = struct{}{}
+}
+
+// remove unregisters a connection, leaving the user's other connections alone
+func (r *clientRegistry) remove(userID string, c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := r.byUser[userID]
+	delete(clients, c)
+	if len(clients) == 0 {
+		delete(r.byUser, userID)
+	}
+}
+
+// get returns a snapshot of the user's connections
+func (r *clientRegistry) get(userID string) []*client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	clients := make([]*client, 0, len(r.byUser[userID]))
+	for c := range r.byUser[userID] {
+		clients = append(clients, c)
+	}
+	return clients
+}
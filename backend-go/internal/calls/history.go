@@ -0,0 +1,64 @@
+package calls
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrCallIDTaken is returned for an offer that reuses the ID of another call
+var ErrCallIDTaken = errors.New("call ID already in use")
+
+// recordCall keeps a call log entry in sync with the signaling messages of a
+// call. Only metadata is stored, never SDP or ICE payloads. A call can only be
+// answered or rejected by its callee and ended by one of its participants.
+func (s *Service) recordCall(msg SignalMessage) error {
+	if s.db == nil || msg.CallID == "" {
+		return nil
+	}
+
+	var err error
+	now := time.Now()
+	switch msg.Type {
+	case "offer", "call":
+		callType, _ := msg.Data["callType"].(string)
+		if callType == "" {
+			callType = "audio"
+		}
+		query := `
+			INSERT INTO call_logs (id, caller_id, callee_id, call_type, status, started_at)
+			VALUES ($1, $2, $3, $4, 'ringing', $5)
+			ON CONFLICT (id) DO NOTHING
+		`
+		var result sql.Result
+		result, err = s.db.Exec(query, msg.CallID, msg.From, msg.To, callType, now)
+		if err == nil {
+			if rows, _ := result.RowsAffected(); rows == 0 {
+				// Re-sent offers of the same call are fine, hijacking another call is not
+				var taken bool
+				query = `SELECT EXISTS(SELECT 1 FROM call_logs WHERE id = $1 AND (caller_id <> $2 OR callee_id <> $3))`
+				if s.db.QueryRow(query, msg.CallID, msg.From, msg.To).Scan(&taken); taken {
+					return ErrCallIDTaken
+				}
+			}
+		}
+	case "answer", "accept":
+		query := `UPDATE call_logs SET status = 'answered', answered_at = $1 WHERE id = $2 AND callee_id = $3 AND answered_at IS NULL`
+		_, err = s.db.Exec(query, now, msg.CallID, msg.From)
+	case "reject":
+		query := `UPDATE call_logs SET status = 'rejected', ended_at = $1 WHERE id = $2 AND callee_id = $3 AND ended_at IS NULL`
+		_, err = s.db.Exec(query, now, msg.CallID, msg.From)
+	case "end":
+		query := `
+			UPDATE call_logs
+			SET status = CASE WHEN answered_at IS NULL THEN 'missed' ELSE 'completed' END, ended_at = $1
+			WHERE id = $2 AND $3 IN (caller_id, callee_id) AND ended_at IS NULL
+		`
+		_, err = s.db.Exec(query, now, msg.CallID, msg.From)
+	}
+	if err != nil {
+		log.Printf("Failed to record call %s: %v", msg.CallID, err)
+	}
+	return nil
+}
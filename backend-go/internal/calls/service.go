@@ -8,6 +8,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/snaptalker/backend/internal/privacy"
 	"github.com/snaptalker/backend/pkg/storage"
 )
 
@@ -21,15 +22,19 @@ var upgrader = websocket.Upgrader{
 
 // Service handles WebRTC signaling
 type Service struct {
+	db      *storage.PostgresDB
 	redis   *storage.RedisClient
-	clients map[string]*websocket.Conn // userID -> websocket connection
+	privacy *privacy.Service
+	clients *clientRegistry
 }
 
 // NewService creates a new calls service
-func NewService(redis *storage.RedisClient) *Service {
+func NewService(db *storage.PostgresDB, redis *storage.RedisClient, privacy *privacy.Service) *Service {
 	return &Service{
+		db:      db,
 		redis:   redis,
-		clients: make(map[string]*websocket.Conn),
+		privacy: privacy,
+		clients: newClientRegistry(),
 	}
 }
 
@@ -59,8 +64,9 @@ func (s *Service) SignalingWebSocket(c *gin.Context) {
 	defer conn.Close()
 
 	// Register client
-	s.clients[userID] = conn
-	defer delete(s.clients, userID)
+	cl := &client{conn: conn, sessionID: c.GetString("sessionId")}
+	s.clients.add(userID, cl)
+	defer s.clients.remove(userID, cl)
 
 	// Listen for signaling messages
 	for {
@@ -74,7 +80,29 @@ func (s *Service) SignalingWebSocket(c *gin.Context) {
 		msg.From = userID
 
 		// Route message to recipient
-		s.routeSignalMessage(msg)
+		if err := s.routeSignalMessage(msg); err != nil {
+			cl.send(map[string]string{"type": "error", "callId": msg.CallID, "error": err.Error()})
+		}
+	}
+}
+
+// DisconnectSession closes the user's signaling WebSockets opened by the given
+// auth session. Called when a session is revoked.
+func (s *Service) DisconnectSession(userID, sessionID string) {
+	for _, cl := range s.clients.get(userID) {
+		if cl.sessionID == sessionID {
+			cl.conn.Close()
+		}
+	}
+}
+
+// DisconnectUser closes every signaling WebSocket of a user, e.g. when an
+// operator suspends or bans the account. Each device is told why first so it
+// does not reconnect.
+func (s *Service) DisconnectUser(userID string) {
+	for _, cl := range s.clients.get(userID) {
+		cl.send(map[string]string{"type": "account_disabled"})
+		cl.conn.Close()
 	}
 }
 
@@ -119,6 +147,11 @@ func (s *Service) SendOffer(c *gin.Context) {
 		return
 	}
 
+	if s.privacy != nil && s.privacy.HasBlocked(userID, req.To) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "unblock this user to call them"})
+		return
+	}
+
 	msg := SignalMessage{
 		Type:   "offer",
 		From:   userID,
@@ -130,7 +163,10 @@ func (s *Service) SendOffer(c *gin.Context) {
 		},
 	}
 
-	s.routeSignalMessage(msg)
+	if err := s.routeSignalMessage(msg); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Store call info in Redis for tracking
 	callInfo := map[string]string{
@@ -180,14 +216,27 @@ func (s *Service) SendAnswer(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "answer sent"})
 }
 
-// routeSignalMessage routes a signaling message to the recipient
-func (s *Service) routeSignalMessage(msg SignalMessage) {
-	if conn, ok := s.clients[msg.To]; ok {
-		// Recipient is online, send via WebSocket
-		conn.WriteJSON(msg)
+// routeSignalMessage routes a signaling message to the recipient. Nothing is
+// routed between users who blocked each other, so offers from a blocked
+// caller never ring. Offers reusing the ID of another call are refused.
+func (s *Service) routeSignalMessage(msg SignalMessage) error {
+	if s.privacy != nil && s.privacy.IsBlocked(msg.From, msg.To) {
+		return nil
+	}
+
+	if err := s.recordCall(msg); err != nil {
+		return err
+	}
+
+	if clients := s.clients.get(msg.To); len(clients) > 0 {
+		// Recipient is online, ring every connected device
+		for _, cl := range clients {
+			cl.send(msg)
+		}
 	} else {
 		// Recipient is offline, could store for later or send push notification
 		// For now, just log it
 		fmt.Printf("User %s is offline, cannot deliver %s message\n", msg.To, msg.Type)
 	}
+	return nil
 }
@@ -2,8 +2,10 @@ package email
 
 import (
 	"fmt"
+	"html"
 	"net/smtp"
 	"os"
+	"time"
 )
 
 type Service struct {
@@ -32,17 +34,129 @@ func getEnv(key, defaultValue string) string {
 }
 
 func (s *Service) SendOTP(toEmail, otp string) error {
+	return s.SendCode(toEmail, "SnapTalker - Password Reset OTP", "Password Reset Request",
+		"We received a request to reset your password.", otp, "1 hour")
+}
+
+// SendCode sends a one-time code email. intro is shown above the code and
+// validity is a human readable expiry such as "10 minutes".
+func (s *Service) SendCode(toEmail, subject, heading, intro, code, validity string) error {
+	content := fmt.Sprintf(`
+        <h2>%s</h2>
+        <p>नमस्ते! %s</p>
+        <p>Your OTP (One-Time Password) is:</p>
+        <div class="otp-code">%s</div>
+        <p><strong>This OTP will expire in %s.</strong></p>
+        <p>If you didn't request this, please ignore this email.</p>`, heading, intro, code, validity)
+
+	return s.send(toEmail, subject, content, fmt.Sprintf("OTP Code: %s", code))
+}
+
+// SendPasswordChanged notifies a user that their password was changed
+func (s *Service) SendPasswordChanged(toEmail, ipAddress string, changedAt time.Time) error {
+	when := changedAt.UTC().Format("02 Jan 2006 15:04 MST")
+	content := fmt.Sprintf(`
+        <h2>Your Password Was Changed</h2>
+        <p>नमस्ते! The password for your SnapTalker account was changed on %s from IP address %s.</p>
+        <p>All other devices have been signed out.</p>
+        <p>If you didn't make this change, reset your password immediately and contact support.</p>`, when, ipAddress)
+
+	return s.send(toEmail, "SnapTalker - Your password was changed", content,
+		fmt.Sprintf("Password changed at %s from %s", when, ipAddress))
+}
+
+// SendDataExportReady tells a user that their account data export can be
+// downloaded from link until expiresAt
+func (s *Service) SendDataExportReady(toEmail, link string, expiresAt time.Time) error {
+	until := expiresAt.UTC().Format("02 Jan 2006 15:04 MST")
+	content := fmt.Sprintf(`
+        <h2>Your Account Information Is Ready</h2>
+        <p>नमस्ते! The export of your SnapTalker account information you requested is ready.</p>
+        <p style="text-align: center;"><a class="button" href="%s">Download your data</a></p>
+        <p><strong>This link expires on %s.</strong> You can request a new link from the app until then.</p>
+        <p>Messages are included exactly as stored on our servers, in encrypted form.</p>
+        <p>If you didn't request this, change your password immediately.</p>`, link, until)
+
+	return s.send(toEmail, "SnapTalker - Your account information is ready", content,
+		fmt.Sprintf("Data export ready: %s (expires %s)", link, until))
+}
+
+// SendEmailVerification asks a user to confirm toEmail by opening link
+// before expiresAt
+func (s *Service) SendEmailVerification(toEmail, link string, expiresAt time.Time) error {
+	until := expiresAt.UTC().Format("02 Jan 2006 15:04 MST")
+	content := fmt.Sprintf(`
+        <h2>Confirm Your Email Address</h2>
+        <p>नमस्ते! Please confirm that %s is your email address for SnapTalker.</p>
+        <p style="text-align: center;"><a class="button" href="%s">Confirm email address</a></p>
+        <p><strong>This link expires on %s.</strong></p>
+        <p>If you didn't add this address to a SnapTalker account, please ignore this email.</p>`,
+		html.EscapeString(toEmail), link, until)
+
+	return s.send(toEmail, "SnapTalker - Confirm your email address", content,
+		fmt.Sprintf("Email verification link: %s (expires %s)", link, until))
+}
+
+// SendEmailChanged tells the previous address of an account that the
+// account email was changed to newEmail
+func (s *Service) SendEmailChanged(toEmail, newEmail string, changedAt time.Time) error {
+	when := changedAt.UTC().Format("02 Jan 2006 15:04 MST")
+	content := fmt.Sprintf(`
+        <h2>Your Email Address Was Changed</h2>
+        <p>नमस्ते! The email address of your SnapTalker account was changed to %s on %s.</p>
+        <p>This address will no longer receive account emails or password reset codes.</p>
+        <p>If you didn't make this change, reset your password immediately and contact support.</p>`,
+		html.EscapeString(newEmail), when)
+
+	return s.send(toEmail, "SnapTalker - Your email address was changed", content,
+		fmt.Sprintf("Email changed to %s at %s", newEmail, when))
+}
+
+// SendLoginLink sends a passwordless sign-in link together with the
+// equivalent one-time code
+func (s *Service) SendLoginLink(toEmail, link, code, validity string) error {
+	content := fmt.Sprintf(`
+        <h2>Sign In to SnapTalker</h2>
+        <p>नमस्ते! Use the button below to sign in to SnapTalker.</p>
+        <p style="text-align: center;"><a class="button" href="%s">Sign in</a></p>
+        <p>Or enter this code in the app:</p>
+        <div class="otp-code">%s</div>
+        <p><strong>The link and code expire in %s and work only once.</strong></p>
+        <p>If you didn't try to sign in, you can ignore this email. Nobody can sign in without it.</p>`, link, code, validity)
+
+	return s.send(toEmail, "SnapTalker - Your sign-in link", content,
+		fmt.Sprintf("Sign-in link: %s (code %s)", link, code))
+}
+
+// SendNewDeviceLogin alerts a user to a login from a device that was not
+// used for their account before; reportLink signs that device out
+func (s *Service) SendNewDeviceLogin(toEmail, device, ipAddress string, loginAt time.Time, reportLink string) error {
+	when := loginAt.UTC().Format("02 Jan 2006 15:04 MST")
+	content := fmt.Sprintf(`
+        <h2>New Sign-In to Your Account</h2>
+        <p>नमस्ते! Your SnapTalker account was signed in to from a new device on %s.</p>
+        <p><strong>Device:</strong> %s<br><strong>IP address:</strong> %s</p>
+        <p>If this was you, you don't need to do anything.</p>
+        <p>If this wasn't you, sign the device out now. You will need to reset your password before you can log in with it again.</p>
+        <p style="text-align: center;"><a class="button" href="%s">This wasn't me</a></p>`,
+		when, html.EscapeString(device), html.EscapeString(ipAddress), reportLink)
+
+	return s.send(toEmail, "SnapTalker - New sign-in to your account", content,
+		fmt.Sprintf("New device login (%s from %s at %s), not me: %s", device, ipAddress, when, reportLink))
+}
+
+// send wraps content in the SnapTalker template and delivers it over SMTP.
+// When SMTP is not configured the summary is printed to the console instead.
+func (s *Service) send(toEmail, subject, content, summary string) error {
 	if s.smtpUsername == "" || s.smtpPassword == "" {
-		// Email not configured - return OTP in console for development
+		// Email not configured - print to console for development
 		fmt.Printf("\n=== EMAIL NOT CONFIGURED ===\n")
-		fmt.Printf("Would send OTP to: %s\n", toEmail)
-		fmt.Printf("OTP Code: %s\n", otp)
+		fmt.Printf("Would send \"%s\" to: %s\n", subject, toEmail)
+		fmt.Printf("%s\n", summary)
 		fmt.Printf("============================\n\n")
 		return nil
 	}
 
-	// Email subject and body
-	subject := "SnapTalker - Password Reset OTP"
 	body := fmt.Sprintf(`
 <!DOCTYPE html>
 <html>
@@ -53,6 +167,7 @@ func (s *Service) SendOTP(toEmail, otp string) error {
         .header { background: linear-gradient(135deg, #FF9933 0%%, #138808 100%%); padding: 20px; border-radius: 10px; text-align: center; }
         .header h1 { color: white; margin: 0; }
         .otp-code { font-size: 32px; font-weight: bold; color: #FF9933; text-align: center; padding: 20px; background: #f9f9f9; border-radius: 10px; margin: 20px 0; letter-spacing: 8px; }
+        .button { display: inline-block; background: #138808; color: white; padding: 12px 24px; border-radius: 6px; text-decoration: none; }
         .footer { text-align: center; color: #666; font-size: 12px; margin-top: 20px; }
     </style>
 </head>
@@ -61,12 +176,7 @@ func (s *Service) SendOTP(toEmail, otp string) error {
         <div class="header">
             <h1>🇮🇳 SnapTalker</h1>
         </div>
-        <h2>Password Reset Request</h2>
-        <p>नमस्ते! We received a request to reset your password.</p>
-        <p>Your OTP (One-Time Password) is:</p>
-        <div class="otp-code">%s</div>
-        <p><strong>This OTP will expire in 1 hour.</strong></p>
-        <p>If you didn't request this password reset, please ignore this email.</p>
+        %s
         <div class="footer">
             <p>Made with love in India | भारत में बनाया गया 🇮🇳</p>
             <p>This is an automated message, please do not reply.</p>
@@ -74,7 +184,7 @@ func (s *Service) SendOTP(toEmail, otp string) error {
     </div>
 </body>
 </html>
-`, otp)
+`, content)
 
 	// Compose message
 	message := []byte(fmt.Sprintf(
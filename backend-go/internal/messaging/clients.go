@@ -0,0 +1,77 @@
+package messaging
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// client is one realtime connection of a user. A user has one per connected
+// device: the primary device and each linked device.
+type client struct {
+	conn      *websocket.Conn
+	sessionID string
+	deviceID  string // empty for the primary device
+
+	writeMu sync.Mutex // a websocket.Conn supports one concurrent writer
+}
+
+func (c *client) send(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+// clientRegistry tracks the open connections of every user
+type clientRegistry struct {
+	mu     sync.RWMutex
+	byUser map[string]map[*client]struct{}
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{byUser: make(map[string]map[*client]struct{})}
+}
+
+// add registers a connection and reports whether it is the user's first
+func (r *clientRegistry) add(userID string, c *client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := r.byUser[userID]
+	if clients == nil {
+		clients = make(map[*client]struct{})
+		r.byUser[userID] = clients
+	}
+	clients[c] = struct{}{}
+	return len(clients) == 1
+}
+
+// remove unregisters a connection and reports whether it was the user's last
+func (r *clientRegistry) remove(userID string, c *client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := r.byUser[userID]
+	delete(clients, c)
+	if len(clients) == 0 {
+		delete(r.byUser, userID)
+		return true
+	}
+	return false
+}
+
+// get returns a snapshot of the user's connections
+func (r *clientRegistry) get(userID string) []*client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	clients := make([]*client, 0, len(r.byUser[userID]))
+	for c := range r.byUser[userID] {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// online reports whether the user has at least one open connection
+func (r *clientRegistry) online(userID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byUser[userID]) > 0
+}
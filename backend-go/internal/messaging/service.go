@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/snaptalker/backend/internal/privacy"
 	"github.com/snaptalker/backend/pkg/storage"
 )
 
@@ -26,17 +27,19 @@ type Service struct {
 	db           *storage.PostgresDB
 	redis        *storage.RedisClient
 	minio        *storage.MinIOClient
-	clients      map[string]*websocket.Conn // userID -> websocket connection
+	privacy      *privacy.Service
+	clients      *clientRegistry            // userID -> open connections, one per device
 	typingStatus map[string]map[string]bool // userID -> map[recipientID]isTyping
 }
 
 // NewService creates a new messaging service
-func NewService(db *storage.PostgresDB, redis *storage.RedisClient, minio *storage.MinIOClient) *Service {
+func NewService(db *storage.PostgresDB, redis *storage.RedisClient, minio *storage.MinIOClient, privacy *privacy.Service) *Service {
 	return &Service{
 		db:           db,
 		redis:        redis,
 		minio:        minio,
-		clients:      make(map[string]*websocket.Conn),
+		privacy:      privacy,
+		clients:      newClientRegistry(),
 		typingStatus: make(map[string]map[string]bool),
 	}
 }
@@ -92,6 +95,13 @@ func (s *Service) SendMessage(c *gin.Context) {
 		return
 	}
 
+	if s.privacy != nil && s.privacy.HasBlocked(senderID, req.RecipientID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "unblock this user to send them messages"})
+		return
+	}
+	// Messages to someone who blocked the sender are dropped without telling them
+	dropped := s.privacy != nil && s.privacy.HasBlocked(req.RecipientID, senderID)
+
 	// Generate message ID
 	messageID := uuid.New().String()
 
@@ -140,6 +150,11 @@ func (s *Service) SendMessage(c *gin.Context) {
 		}
 	}
 
+	if dropped {
+		c.JSON(http.StatusOK, message)
+		return
+	}
+
 	query := `
 		INSERT INTO messages (id, sender_id, recipient_id, content, content_type, encrypted, timestamp, status, message_type, reply_to_id)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
@@ -300,6 +315,15 @@ func (s *Service) GetMessages(c *gin.Context) {
 		messages = append(messages, msg)
 	}
 
+	// Without read receipts between the two users, read looks like delivered
+	if !s.readReceiptsShared(chatID, userID) {
+		for i := range messages {
+			if messages[i].SenderID == userID && messages[i].Status == "read" {
+				messages[i].Status = "delivered"
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"messages": messages,
 		"count":    len(messages),
@@ -344,7 +368,7 @@ func (s *Service) UpdateMessageStatus(c *gin.Context) {
 	}
 
 	// Notify sender via WebSocket
-	s.notifyStatusUpdate(messageID, req.Status)
+	s.notifyStatusUpdate(userID, messageID, req.Status)
 
 	c.JSON(http.StatusOK, gin.H{
 		"messageId": messageID,
@@ -368,12 +392,12 @@ func (s *Service) StreamMessages(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	// Register client
-	s.clients[userID] = conn
-	defer delete(s.clients, userID)
-
-	// Broadcast user online status
-	s.broadcastUserStatus(userID, true)
+	// Register client; every device of the user keeps its own connection
+	cl := &client{conn: conn, sessionID: c.GetString("sessionId"), deviceID: c.GetString("deviceId")}
+	if s.clients.add(userID, cl) {
+		// Broadcast user online status when the first device connects
+		s.broadcastUserStatus(userID, true)
+	}
 
 	// Set user as online in Redis
 	if s.redis != nil {
@@ -381,7 +405,7 @@ func (s *Service) StreamMessages(c *gin.Context) {
 	}
 
 	// Send any pending messages
-	s.sendPendingMessages(conn, userID)
+	s.sendPendingMessages(cl, userID)
 
 	// Keep connection alive with pings
 	go func() {
@@ -390,7 +414,7 @@ func (s *Service) StreamMessages(c *gin.Context) {
 		for {
 			select {
 			case <-ticker.C:
-				if err := conn.WriteJSON(map[string]string{"type": "ping"}); err != nil {
+				if err := cl.send(map[string]string{"type": "ping"}); err != nil {
 					return
 				}
 			}
@@ -415,7 +439,7 @@ func (s *Service) StreamMessages(c *gin.Context) {
 		case "message_read":
 			// Handle read receipt
 			if messageID, ok := wsMsg["messageId"].(string); ok {
-				s.notifyStatusUpdate(messageID, "read")
+				s.notifyStatusUpdate(userID, messageID, "read")
 			}
 		case "typing":
 			// Handle typing indicator
@@ -429,6 +453,9 @@ func (s *Service) StreamMessages(c *gin.Context) {
 		case "heartbeat":
 			// Update last seen timestamp
 			s.updateLastSeen(userID)
+			if cl.deviceID != "" {
+				s.updateDeviceLastSeen(cl.deviceID)
+			}
 			if s.redis != nil {
 				s.redis.Set(c.Request.Context(), fmt.Sprintf("online:%s", userID), "true", 90*time.Second)
 			}
@@ -438,6 +465,11 @@ func (s *Service) StreamMessages(c *gin.Context) {
 		}
 	}
 
+	// The user stays online while another device is connected
+	if !s.clients.remove(userID, cl) {
+		return
+	}
+
 	// Set user as offline and broadcast
 	if s.redis != nil {
 		s.redis.Delete(c.Request.Context(), fmt.Sprintf("online:%s", userID))
@@ -450,9 +482,41 @@ func (s *Service) StreamMessages(c *gin.Context) {
 	delete(s.typingStatus, userID)
 }
 
+// DisconnectSession closes the user's WebSockets that were opened by the
+// given auth session. Called when a session is revoked.
+func (s *Service) DisconnectSession(userID, sessionID string) {
+	for _, cl := range s.clients.get(userID) {
+		if cl.sessionID == sessionID {
+			cl.send(map[string]string{"type": "session_revoked"})
+			cl.conn.Close()
+		}
+	}
+}
+
+// DisconnectUser closes every WebSocket of a user, e.g. when an operator
+// suspends the account
+func (s *Service) DisconnectUser(userID string) {
+	for _, cl := range s.clients.get(userID) {
+		cl.send(map[string]string{"type": "account_disabled"})
+		cl.conn.Close()
+	}
+}
+
+// SendToUser delivers an event to every connected device of a user and
+// reports whether at least one device received it
+func (s *Service) SendToUser(userID string, event map[string]interface{}) bool {
+	delivered := false
+	for _, cl := range s.clients.get(userID) {
+		if cl.send(event) == nil {
+			delivered = true
+		}
+	}
+	return delivered
+}
+
 // deliverMessage attempts to deliver a message to the recipient if online
 func (s *Service) deliverMessage(msg Message) {
-	if conn, ok := s.clients[msg.RecipientID]; ok {
+	if s.clients.online(msg.RecipientID) {
 		// Recipient is online, send via WebSocket
 		notification := map[string]interface{}{
 			"type":        "new_message",
@@ -464,23 +528,22 @@ func (s *Service) deliverMessage(msg Message) {
 			"encrypted":   msg.Encrypted,
 			"status":      "delivered",
 		}
-		conn.WriteJSON(notification)
+		if !s.SendToUser(msg.RecipientID, notification) {
+			return
+		}
 
 		// Notify sender that message was delivered
-		if senderConn, ok := s.clients[msg.SenderID]; ok {
-			statusUpdate := map[string]interface{}{
-				"type":      "status_update",
-				"messageId": msg.ID,
-				"status":    "delivered",
-			}
-			senderConn.WriteJSON(statusUpdate)
-		}
+		s.SendToUser(msg.SenderID, map[string]interface{}{
+			"type":      "status_update",
+			"messageId": msg.ID,
+			"status":    "delivered",
+		})
 	}
 	// If offline, message is already stored in database for later retrieval
 }
 
 // sendPendingMessages sends any pending messages to a newly connected user
-func (s *Service) sendPendingMessages(conn *websocket.Conn, userID string) {
+func (s *Service) sendPendingMessages(cl *client, userID string) {
 	query := `
 		SELECT id, sender_id, recipient_id, content, content_type, encrypted, timestamp, status, message_type
 		FROM messages
@@ -500,30 +563,61 @@ func (s *Service) sendPendingMessages(conn *websocket.Conn, userID string) {
 		if err != nil {
 			continue
 		}
-		conn.WriteJSON(msg)
+		cl.send(msg)
 	}
 }
 
-// notifyStatusUpdate notifies the sender about message status changes
-func (s *Service) notifyStatusUpdate(messageID, status string) {
+// notifyStatusUpdate notifies the sender about message status changes made
+// by readerID; read receipts are only sent if both users share them
+func (s *Service) notifyStatusUpdate(readerID, messageID, status string) {
 	// Get sender ID from message
 	var senderID string
 	query := `SELECT sender_id FROM messages WHERE id = $1`
 	s.db.QueryRow(query, messageID).Scan(&senderID)
 
+	if status == "read" && !s.readReceiptsShared(readerID, senderID) {
+		return
+	}
+
 	// Send status update if sender is online
-	if conn, ok := s.clients[senderID]; ok {
-		conn.WriteJSON(map[string]interface{}{
-			"type":      "status_update",
-			"messageId": messageID,
-			"status":    status,
-		})
+	s.SendToUser(senderID, map[string]interface{}{
+		"type":      "status_update",
+		"messageId": messageID,
+		"status":    status,
+	})
+}
+
+// BroadcastToContacts sends an event to every online user who has a
+// conversation with userID. The event is built per recipient; recipients
+// for whom it returns nil are skipped.
+func (s *Service) BroadcastToContacts(userID string, event func(recipientID string) map[string]interface{}) {
+	for _, otherUserID := range s.conversationPartners(userID) {
+		if !s.clients.online(otherUserID) || s.isBlocked(userID, otherUserID) {
+			continue
+		}
+		if notification := event(otherUserID); notification != nil {
+			s.SendToUser(otherUserID, notification)
+		}
 	}
 }
 
-// broadcastUserStatus notifies all relevant users about online/offline status
-func (s *Service) broadcastUserStatus(userID string, online bool) {
-	// Get all users who have conversations with this user
+// isBlocked reports whether either user has blocked the other
+func (s *Service) isBlocked(userID, otherUserID string) bool {
+	return s.privacy != nil && s.privacy.IsBlocked(userID, otherUserID)
+}
+
+// canSee reports whether viewerID may see userID's setting
+func (s *Service) canSee(userID, viewerID string, setting privacy.Setting) bool {
+	return s.privacy == nil || s.privacy.CanSee(userID, viewerID, setting)
+}
+
+// readReceiptsShared reports whether readerID's read receipts reach senderID
+func (s *Service) readReceiptsShared(readerID, senderID string) bool {
+	return s.canSee(readerID, senderID, privacy.ReadReceipts)
+}
+
+// conversationPartners returns all users who have exchanged messages with userID
+func (s *Service) conversationPartners(userID string) []string {
 	query := `
 		SELECT DISTINCT 
 			CASE 
@@ -535,39 +629,57 @@ func (s *Service) broadcastUserStatus(userID string, online bool) {
 	`
 	rows, err := s.db.Query(query, userID)
 	if err != nil {
-		return
+		return nil
 	}
 	defer rows.Close()
 
+	var partners []string
+	for rows.Next() {
+		var otherUserID string
+		if err := rows.Scan(&otherUserID); err != nil {
+			continue
+		}
+		partners = append(partners, otherUserID)
+	}
+	return partners
+}
+
+// broadcastUserStatus notifies all relevant users about online/offline
+// status, as far as each of them may see it
+func (s *Service) broadcastUserStatus(userID string, online bool) {
 	statusType := "user_offline"
 	if online {
 		statusType = "user_online"
 	}
 
-	notification := map[string]interface{}{
-		"type":   statusType,
-		"userId": userID,
-	}
-
+	var lastSeen time.Time
 	if !online {
 		// Get last seen from database
-		var lastSeen time.Time
 		query := `SELECT last_seen FROM users WHERE id = $1`
 		s.db.QueryRow(query, userID).Scan(&lastSeen)
-		notification["lastSeen"] = lastSeen
 	}
 
-	for rows.Next() {
-		var otherUserID string
-		if err := rows.Scan(&otherUserID); err != nil {
-			continue
+	s.BroadcastToContacts(userID, func(recipientID string) map[string]interface{} {
+		showOnline := s.canSee(userID, recipientID, privacy.Online)
+		if online && !showOnline {
+			return nil
 		}
 
-		// Notify if the other user is online
-		if conn, ok := s.clients[otherUserID]; ok {
-			conn.WriteJSON(notification)
+		notification := map[string]interface{}{
+			"type":   statusType,
+			"userId": userID,
 		}
-	}
+		if !online {
+			showLastSeen := s.canSee(userID, recipientID, privacy.LastSeen)
+			if !showOnline && !showLastSeen {
+				return nil
+			}
+			if showLastSeen {
+				notification["lastSeen"] = lastSeen
+			}
+		}
+		return notification
+	})
 }
 
 // handleTypingIndicator broadcasts typing status to recipient
@@ -579,13 +691,19 @@ func (s *Service) handleTypingIndicator(userID, recipientID string, isTyping boo
 	s.typingStatus[userID][recipientID] = isTyping
 
 	// Notify recipient if online
-	if conn, ok := s.clients[recipientID]; ok {
-		notification := map[string]interface{}{
+	if s.clients.online(recipientID) && !s.isBlocked(userID, recipientID) {
+		s.SendToUser(recipientID, map[string]interface{}{
 			"type":     "typing",
 			"userId":   userID,
 			"isTyping": isTyping,
-		}
-		conn.WriteJSON(notification)
+		})
+	}
+}
+
+// updateDeviceLastSeen records activity of a linked device
+func (s *Service) updateDeviceLastSeen(deviceID string) {
+	if _, err := s.db.Exec(`UPDATE devices SET last_seen = $1 WHERE id = $2`, time.Now(), deviceID); err != nil {
+		log.Printf("Failed to update last seen for device %s: %v", deviceID, err)
 	}
 }
 
@@ -621,6 +739,18 @@ func (s *Service) AddReaction(c *gin.Context) {
 	// Generate reaction ID
 	reactionID := uuid.New().String()
 
+	// Reactions between blocked users are dropped without telling the reactor
+	var senderID, recipientID string
+	s.db.QueryRow(`SELECT sender_id, recipient_id FROM messages WHERE id = $1`, req.MessageID).Scan(&senderID, &recipientID)
+	if s.isBlocked(senderID, recipientID) {
+		c.JSON(http.StatusOK, gin.H{
+			"id":        reactionID,
+			"messageId": req.MessageID,
+			"emoji":     req.Emoji,
+		})
+		return
+	}
+
 	// Check if user already reacted to this message
 	var existingID string
 	checkQuery := `SELECT id FROM message_reactions WHERE message_id = $1 AND user_id = $2`
@@ -732,6 +862,9 @@ func (s *Service) broadcastReaction(messageID, userID, username, emoji, action s
 	var senderID, recipientID string
 	query := `SELECT sender_id, recipient_id FROM messages WHERE id = $1`
 	s.db.QueryRow(query, messageID).Scan(&senderID, &recipientID)
+	if s.isBlocked(senderID, recipientID) {
+		return
+	}
 
 	notification := map[string]interface{}{
 		"type":      "reaction",
@@ -744,15 +877,11 @@ func (s *Service) broadcastReaction(messageID, userID, username, emoji, action s
 
 	// Notify sender if different from reactor
 	if senderID != userID {
-		if conn, ok := s.clients[senderID]; ok {
-			conn.WriteJSON(notification)
-		}
+		s.SendToUser(senderID, notification)
 	}
 
 	// Notify recipient if different from reactor
 	if recipientID != userID {
-		if conn, ok := s.clients[recipientID]; ok {
-			conn.WriteJSON(notification)
-		}
+		s.SendToUser(recipientID, notification)
 	}
 }
@@ -0,0 +1,217 @@
+package privacy
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// reportMessageCount is how many recent messages from the reported user
+	// are kept with a report
+	reportMessageCount = 5
+	maxReportReason    = 500
+)
+
+// BlockUserRequest blocks a user, optionally reporting them
+type BlockUserRequest struct {
+	UserID string `json:"userId" binding:"required"`
+	// Report also files a moderation report with the last few messages
+	// the blocked user sent
+	Report bool   `json:"report"`
+	Reason string `json:"reason"`
+}
+
+// BlockedUser is an entry of a user's block list
+type BlockedUser struct {
+	UserID      string    `json:"userId"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName,omitempty"`
+	BlockedAt   time.Time `json:"blockedAt"`
+}
+
+// ReportedMessage is a message snapshot attached to a report. Content is
+// stored as the server holds it, i.e. ciphertext for encrypted messages.
+type ReportedMessage struct {
+	ID          string    `json:"id"`
+	Content     string    `json:"content"`
+	ContentType string    `json:"contentType"`
+	Encrypted   bool      `json:"encrypted"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// BlockUser adds a user to the current user's block list
+func (s *Service) BlockUser(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req BlockUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot block yourself"})
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if len(reason) > maxReportReason {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is too long"})
+		return
+	}
+
+	var exists bool
+	s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, req.UserID).Scan(&exists)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to block user"})
+		return
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO blocked_users (user_id, blocked_user_id, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := tx.Exec(query, userID, req.UserID, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to block user"})
+		return
+	}
+
+	var reportID string
+	if req.Report {
+		messages, err := s.recentMessages(req.UserID, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to report user"})
+			return
+		}
+		snapshot, _ := json.Marshal(messages)
+		reportID = uuid.New().String()
+		query := `
+			INSERT INTO user_reports (id, reporter_id, reported_user_id, reason, messages, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`
+		if _, err := tx.Exec(query, reportID, userID, req.UserID, reason, string(snapshot), time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to report user"})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to block user"})
+		return
+	}
+
+	response := gin.H{"message": "user blocked", "userId": req.UserID}
+	if reportID != "" {
+		response["reportId"] = reportID
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// UnblockUser removes a user from the current user's block list
+func (s *Service) UnblockUser(c *gin.Context) {
+	userID := c.GetString("userId")
+	blockedID := c.Param("userId")
+
+	result, err := s.db.Exec(`DELETE FROM blocked_users WHERE user_id = $1 AND blocked_user_id = $2`, userID, blockedID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unblock user"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user is not blocked"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "user unblocked", "userId": blockedID})
+}
+
+// GetBlockedUsers lists the current user's block list
+func (s *Service) GetBlockedUsers(c *gin.Context) {
+	userID := c.GetString("userId")
+	query := `
+		SELECT b.blocked_user_id, u.username, u.display_name, b.created_at
+		FROM blocked_users b
+		JOIN users u ON u.id = b.blocked_user_id
+		WHERE b.user_id = $1
+		ORDER BY b.created_at DESC
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load blocked users"})
+		return
+	}
+	defer rows.Close()
+
+	blocked := []BlockedUser{}
+	for rows.Next() {
+		var entry BlockedUser
+		var displayName sql.NullString
+		if err := rows.Scan(&entry.UserID, &entry.Username, &displayName, &entry.BlockedAt); err != nil {
+			continue
+		}
+		entry.DisplayName = displayName.String
+		blocked = append(blocked, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"blocked": blocked,
+		"count":   len(blocked),
+	})
+}
+
+// HasBlocked reports whether blockerID has blocked userID
+func (s *Service) HasBlocked(blockerID, userID string) bool {
+	var blocked bool
+	query := `SELECT EXISTS(SELECT 1 FROM blocked_users WHERE user_id = $1 AND blocked_user_id = $2)`
+	s.db.QueryRow(query, blockerID, userID).Scan(&blocked)
+	return blocked
+}
+
+// IsBlocked reports whether either user has blocked the other
+func (s *Service) IsBlocked(userID, otherUserID string) bool {
+	var blocked bool
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM blocked_users
+			WHERE (user_id = $1 AND blocked_user_id = $2) OR (user_id = $2 AND blocked_user_id = $1)
+		)
+	`
+	s.db.QueryRow(query, userID, otherUserID).Scan(&blocked)
+	return blocked
+}
+
+// recentMessages returns the last messages senderID sent to recipientID
+func (s *Service) recentMessages(senderID, recipientID string) ([]ReportedMessage, error) {
+	query := `
+		SELECT id, content, content_type, encrypted, timestamp
+		FROM messages
+		WHERE sender_id = $1 AND recipient_id = $2
+		ORDER BY timestamp DESC
+		LIMIT $3
+	`
+	rows, err := s.db.Query(query, senderID, recipientID, reportMessageCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ReportedMessage{}
+	for rows.Next() {
+		var msg ReportedMessage
+		if err := rows.Scan(&msg.ID, &msg.Content, &msg.ContentType, &msg.Encrypted, &msg.Timestamp); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
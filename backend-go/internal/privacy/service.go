@@ -0,0 +1,335 @@
+package privacy
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snaptalker/backend/pkg/storage"
+)
+
+// Audience is who may see a piece of profile or presence information
+type Audience string
+
+const (
+	Everyone       Audience = "everyone"
+	Contacts       Audience = "contacts" // users the owner has exchanged messages with
+	Nobody         Audience = "nobody"
+	EveryoneExcept Audience = "everyone_except"
+)
+
+// Setting names a piece of information governed by an Audience
+type Setting string
+
+const (
+	LastSeen     Setting = "last_seen"
+	Online       Setting = "online"
+	ProfilePhoto Setting = "profile_photo"
+	About        Setting = "about"
+	ReadReceipts Setting = "read_receipts"
+)
+
+// maxExceptions bounds each everyone-except list
+const maxExceptions = 1000
+
+var allSettings = []Setting{LastSeen, Online, ProfilePhoto, About, ReadReceipts}
+
+// Settings are a user's privacy choices
+type Settings struct {
+	LastSeen     Audience `json:"lastSeen"`
+	Online       Audience `json:"online"`
+	ProfilePhoto Audience `json:"profilePhoto"`
+	About        Audience `json:"about"`
+	ReadReceipts Audience `json:"readReceipts"`
+	// Exceptions lists the users excluded by an everyone_except audience
+	Exceptions map[Setting][]string `json:"exceptions"`
+}
+
+// DefaultSettings applies to users who never changed their privacy settings
+func DefaultSettings() *Settings {
+	return &Settings{
+		LastSeen:     Everyone,
+		Online:       Everyone,
+		ProfilePhoto: Everyone,
+		About:        Everyone,
+		ReadReceipts: Everyone,
+		Exceptions:   map[Setting][]string{},
+	}
+}
+
+// Audience returns the audience configured for setting
+func (p *Settings) Audience(setting Setting) Audience {
+	switch setting {
+	case LastSeen:
+		return p.LastSeen
+	case Online:
+		return p.Online
+	case ProfilePhoto:
+		return p.ProfilePhoto
+	case About:
+		return p.About
+	case ReadReceipts:
+		return p.ReadReceipts
+	}
+	return Nobody
+}
+
+func (p *Settings) setAudience(setting Setting, audience Audience) {
+	switch setting {
+	case LastSeen:
+		p.LastSeen = audience
+	case Online:
+		p.Online = audience
+	case ProfilePhoto:
+		p.ProfilePhoto = audience
+	case About:
+		p.About = audience
+	case ReadReceipts:
+		p.ReadReceipts = audience
+	}
+}
+
+// UpdateSettingsRequest is a partial privacy update. An exceptions entry
+// replaces the whole list for that setting.
+type UpdateSettingsRequest struct {
+	LastSeen     *Audience            `json:"lastSeen"`
+	Online       *Audience            `json:"online"`
+	ProfilePhoto *Audience            `json:"profilePhoto"`
+	About        *Audience            `json:"about"`
+	ReadReceipts *Audience            `json:"readReceipts"`
+	Exceptions   map[Setting][]string `json:"exceptions"`
+}
+
+// Service stores privacy settings and answers visibility questions
+type Service struct {
+	db *storage.PostgresDB
+}
+
+// NewService creates a new privacy service
+func NewService(db *storage.PostgresDB) *Service {
+	return &Service{db: db}
+}
+
+// GetSettings returns the current user's privacy settings
+func (s *Service) GetSettings(c *gin.Context) {
+	userID := c.GetString("userId")
+	settings, err := s.Settings(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load privacy settings"})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings applies a partial update to the current user's privacy settings
+func (s *Service) UpdateSettings(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req UpdateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	current, err := s.Settings(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load privacy settings"})
+		return
+	}
+
+	updates := map[Setting]*Audience{
+		LastSeen:     req.LastSeen,
+		Online:       req.Online,
+		ProfilePhoto: req.ProfilePhoto,
+		About:        req.About,
+		ReadReceipts: req.ReadReceipts,
+	}
+	for setting, audience := range updates {
+		if audience == nil {
+			continue
+		}
+		if !validAudience(*audience) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid audience %q for %s", *audience, setting)})
+			return
+		}
+		current.setAudience(setting, *audience)
+	}
+	for setting, userIDs := range req.Exceptions {
+		if !validSetting(setting) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown setting %q", setting)})
+			return
+		}
+		if len(userIDs) > maxExceptions {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d exceptions per setting", maxExceptions)})
+			return
+		}
+	}
+
+	if err := s.save(userID, current, req.Exceptions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update privacy settings"})
+		return
+	}
+
+	updated, err := s.Settings(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load privacy settings"})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// Settings loads a user's privacy settings, falling back to the defaults
+func (s *Service) Settings(userID string) (*Settings, error) {
+	settings := DefaultSettings()
+	var lastSeen, online, profilePhoto, about, readReceipts string
+	query := `
+		SELECT last_seen, online, profile_photo, about, read_receipts
+		FROM privacy_settings WHERE user_id = $1
+	`
+	err := s.db.QueryRow(query, userID).Scan(&lastSeen, &online, &profilePhoto, &about, &readReceipts)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	settings.LastSeen = Audience(lastSeen)
+	settings.Online = Audience(online)
+	settings.ProfilePhoto = Audience(profilePhoto)
+	settings.About = Audience(about)
+	settings.ReadReceipts = Audience(readReceipts)
+
+	rows, err := s.db.Query(`SELECT setting, excluded_user_id FROM privacy_exceptions WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var setting, excludedID string
+		if err := rows.Scan(&setting, &excludedID); err != nil {
+			return nil, err
+		}
+		settings.Exceptions[Setting(setting)] = append(settings.Exceptions[Setting(setting)], excludedID)
+	}
+	return settings, rows.Err()
+}
+
+// CanSee reports whether viewerID may see ownerID's setting. Last seen and
+// read receipts are reciprocal: viewers who hide their own from the owner
+// cannot see the owner's either. Blocked users see nothing in either
+// direction.
+func (s *Service) CanSee(ownerID, viewerID string, setting Setting) bool {
+	if ownerID == viewerID {
+		return true
+	}
+	if s.IsBlocked(ownerID, viewerID) {
+		return false
+	}
+
+	owner, err := s.Settings(ownerID)
+	if err != nil || !s.allows(owner, ownerID, viewerID, setting) {
+		return false
+	}
+
+	if setting == LastSeen || setting == ReadReceipts {
+		viewer, err := s.Settings(viewerID)
+		if err != nil || !s.allows(viewer, viewerID, ownerID, setting) {
+			return false
+		}
+	}
+	return true
+}
+
+// allows applies owner's audience for setting to viewerID
+func (s *Service) allows(owner *Settings, ownerID, viewerID string, setting Setting) bool {
+	switch owner.Audience(setting) {
+	case Everyone:
+		return true
+	case Contacts:
+		return s.IsContact(ownerID, viewerID)
+	case EveryoneExcept:
+		for _, excludedID := range owner.Exceptions[setting] {
+			if excludedID == viewerID {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// IsContact reports whether two users have exchanged messages
+func (s *Service) IsContact(userID, otherUserID string) bool {
+	var exists bool
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM messages
+			WHERE (sender_id = $1 AND recipient_id = $2) OR (sender_id = $2 AND recipient_id = $1)
+		)
+	`
+	s.db.QueryRow(query, userID, otherUserID).Scan(&exists)
+	return exists
+}
+
+func (s *Service) save(userID string, settings *Settings, exceptions map[Setting][]string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO privacy_settings (user_id, last_seen, online, profile_photo, about, read_receipts, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id) DO UPDATE SET
+			last_seen = EXCLUDED.last_seen,
+			online = EXCLUDED.online,
+			profile_photo = EXCLUDED.profile_photo,
+			about = EXCLUDED.about,
+			read_receipts = EXCLUDED.read_receipts,
+			updated_at = EXCLUDED.updated_at
+	`
+	_, err = tx.Exec(query, userID, string(settings.LastSeen), string(settings.Online),
+		string(settings.ProfilePhoto), string(settings.About), string(settings.ReadReceipts), time.Now())
+	if err != nil {
+		return err
+	}
+
+	for setting, userIDs := range exceptions {
+		if _, err := tx.Exec(`DELETE FROM privacy_exceptions WHERE user_id = $1 AND setting = $2`, userID, string(setting)); err != nil {
+			return err
+		}
+		for _, excludedID := range userIDs {
+			// Unknown user IDs are dropped
+			query := `
+				INSERT INTO privacy_exceptions (user_id, setting, excluded_user_id)
+				SELECT $1, $2, id FROM users WHERE id = $3
+				ON CONFLICT DO NOTHING
+			`
+			if _, err := tx.Exec(query, userID, string(setting), excludedID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func validAudience(audience Audience) bool {
+	switch audience {
+	case Everyone, Contacts, Nobody, EveryoneExcept:
+		return true
+	}
+	return false
+}
+
+func validSetting(setting Setting) bool {
+	for _, known := range allSettings {
+		if setting == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package security
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/snaptalker/backend/pkg/storage"
+)
+
+// EventType names a security-relevant account event
+type EventType string
+
+const (
+	Login              EventType = "login"
+	LoginFailed        EventType = "login_failed"
+	NewDeviceLogin     EventType = "new_device_login"
+	LoginReported      EventType = "login_reported" // the owner did not recognise a login
+	PasswordReset      EventType = "password_reset"
+	PasswordChanged    EventType = "password_changed"
+	OTPVerified        EventType = "otp_verified"
+	OTPFailed          EventType = "otp_failed"
+	TwoFactorDisabled  EventType = "two_factor_disabled"
+	IdentityKeyChanged EventType = "identity_key_changed"
+	SessionRevoked     EventType = "session_revoked"
+	AdminAction        EventType = "admin_action"
+)
+
+const (
+	// DefaultRetention is how long events are kept unless configured otherwise
+	DefaultRetention = 180 * 24 * time.Hour
+	// maxEventsPage caps GET /users/me/security-events
+	maxEventsPage = 100
+)
+
+// Event is one entry of the security event log. UserID is empty for events
+// that could not be attributed to an account, such as a login attempt for
+// an unknown phone number. ActorID is set when someone other than the
+// account owner caused the event, e.g. an operator.
+type Event struct {
+	ID        string                 `json:"id"`
+	UserID    string                 `json:"-"`
+	Type      EventType              `json:"type"`
+	ActorID   string                 `json:"-"`
+	IPAddress string                 `json:"ipAddress,omitempty"`
+	UserAgent string                 `json:"userAgent,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+// Log is the Postgres-backed security event log. A nil *Log records nothing,
+// so services can be built without one.
+type Log struct {
+	db        *storage.PostgresDB
+	retention time.Duration
+}
+
+// NewLog creates a security event log that keeps events for retention
+func NewLog(db *storage.PostgresDB, retention time.Duration) *Log {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &Log{db: db, retention: retention}
+}
+
+// Record stores an event. Failures are logged and never fail the request
+// that caused the event.
+func (l *Log) Record(event Event) {
+	if l == nil {
+		return
+	}
+	var details interface{}
+	if len(event.Details) > 0 {
+		encoded, _ := json.Marshal(event.Details)
+		details = string(encoded)
+	}
+	query := `
+		INSERT INTO security_events (id, user_id, event_type, actor_id, ip_address, user_agent, details, created_at)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), $7, $8)
+	`
+	_, err := l.db.Exec(query, uuid.New().String(), event.UserID, string(event.Type), event.ActorID,
+		event.IPAddress, event.UserAgent, details, time.Now())
+	if err != nil {
+		log.Printf("Failed to record %s security event for %q: %v", event.Type, event.UserID, err)
+	}
+}
+
+// RecordRequest stores an event with the IP address and user agent of the
+// request that caused it. c may be nil for events raised by background jobs.
+func (l *Log) RecordRequest(c *gin.Context, userID string, eventType EventType, details map[string]interface{}) {
+	event := Event{UserID: userID, Type: eventType, Details: details}
+	if c != nil {
+		event.IPAddress = c.ClientIP()
+		event.UserAgent = c.Request.UserAgent()
+	}
+	l.Record(event)
+}
+
+// GetMyEvents lists the current user's security events, newest first. Pass
+// the createdAt of the last event as before to page back. The IP address
+// and user agent of events caused by an operator are not shown.
+func (l *Log) GetMyEvents(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 {
+		limit = 50
+	} else if limit > maxEventsPage {
+		limit = maxEventsPage
+	}
+	before := time.Now()
+	if raw := c.Query("before"); raw != "" {
+		if before, err = time.Parse(time.RFC3339Nano, raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+
+	query := `
+		SELECT id, event_type, actor_id, ip_address, user_agent, details, created_at
+		FROM security_events
+		WHERE user_id = $1 AND created_at < $2 AND ($3 = '' OR event_type = $3)
+		ORDER BY created_at DESC
+		LIMIT $4
+	`
+	rows, err := l.db.Query(query, userID, before, c.Query("type"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get security events"})
+		return
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		var actorID, ipAddress, userAgent sql.NullString
+		var details []byte
+		if err := rows.Scan(&event.ID, &event.Type, &actorID, &ipAddress, &userAgent, &details, &event.CreatedAt); err != nil {
+			continue
+		}
+		if details != nil {
+			json.Unmarshal(details, &event.Details)
+		}
+		if !actorID.Valid || actorID.String == userID {
+			event.IPAddress = ipAddress.String
+			event.UserAgent = userAgent.String
+		}
+		events = append(events, event)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":        events,
+		"retentionDays": int(l.retention.Hours() / 24),
+	})
+}
+
+// StartRetentionWorker deletes events older than the retention period on
+// every tick until ctx is done
+func (l *Log) StartRetentionWorker(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			l.purgeExpired()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (l *Log) purgeExpired() {
+	result, err := l.db.Exec(`DELETE FROM security_events WHERE created_at < $1`, time.Now().Add(-l.retention))
+	if err != nil {
+		log.Printf("Failed to purge security events: %v", err)
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		log.Printf("Purged %d security events older than %s", rows, l.retention)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/snaptalker/backend/internal/security"
 	"github.com/snaptalker/backend/pkg/storage"
 )
 
@@ -21,15 +22,17 @@ var (
 
 // Service handles Signal Protocol key exchange
 type Service struct {
-	db    *storage.PostgresDB
-	redis *storage.RedisClient
+	db          *storage.PostgresDB
+	redis       *storage.RedisClient
+	securityLog *security.Log
 }
 
 // NewService creates a new Signal service
-func NewService(db *storage.PostgresDB, redis *storage.RedisClient) *Service {
+func NewService(db *storage.PostgresDB, redis *storage.RedisClient, securityLog *security.Log) *Service {
 	return &Service{
-		db:    db,
-		redis: redis,
+		db:          db,
+		redis:       redis,
+		securityLog: securityLog,
 	}
 }
 
@@ -93,6 +96,9 @@ func (s *Service) UploadKeyBundle(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
+	var previousIdentityKey sql.NullString
+	tx.QueryRow(`SELECT identity_key FROM users WHERE id = $1`, userID).Scan(&previousIdentityKey)
+
 	// Update user's identity key and signed pre-key
 	query := `
 		INSERT INTO users (id, identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature, updated_at)
@@ -131,6 +137,10 @@ func (s *Service) UploadKeyBundle(c *gin.Context) {
 		return
 	}
 
+	if previousIdentityKey.Valid && previousIdentityKey.String != req.IdentityKey {
+		s.securityLog.RecordRequest(c, userID, security.IdentityKeyChanged, map[string]interface{}{"reason": "key_upload"})
+	}
+
 	// Cache the key bundle in Redis for fast access
 	bundleJSON, _ := json.Marshal(req)
 	if s.redis != nil {
@@ -293,6 +303,9 @@ func (s *Service) GetPreKeyCount(userID string) (int, error) {
 
 // logKeyExchange logs a key exchange event for security auditing
 func (s *Service) logKeyExchange(ctx context.Context, initiatorID, recipientID string) {
+	if s.redis == nil {
+		return
+	}
 	// Store in Redis for recent activity tracking
 	logEntry := map[string]interface{}{
 		"initiator": initiatorID,
@@ -0,0 +1,218 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownPasswordHash is returned for stored hashes in an unsupported format
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// Argon2Params are the argon2id cost parameters. They are encoded in every
+// hash, so changing them only affects new hashes and rehashes.
+type Argon2Params struct {
+	Memory     uint32 // KiB
+	Time       uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// DefaultArgon2Params follow the OWASP minimum recommendation for argon2id
+var DefaultArgon2Params = Argon2Params{
+	Memory:     19 * 1024,
+	Time:       2,
+	Threads:    1,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+// PasswordHasher hashes passwords with argon2id and verifies both argon2id
+// and legacy bcrypt hashes
+type PasswordHasher struct {
+	params Argon2Params
+}
+
+// NewPasswordHasher creates a hasher producing argon2id hashes with params
+func NewPasswordHasher(params Argon2Params) (*PasswordHasher, error) {
+	if params.Memory < 8*uint32(params.Threads) || params.Time == 0 || params.Threads == 0 {
+		return nil, fmt.Errorf("invalid argon2id parameters m=%d,t=%d,p=%d", params.Memory, params.Time, params.Threads)
+	}
+	if params.SaltLength < 8 || params.KeyLength < 16 {
+		return nil, fmt.Errorf("argon2id salt must be at least 8 bytes and key at least 16 bytes")
+	}
+	return &PasswordHasher{params: params}, nil
+}
+
+// Hash returns a PHC-formatted argon2id hash of password:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	salt, err := GenerateRandomBytes(int(h.params.SaltLength))
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Time, h.params.Memory, h.params.Threads, h.params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.Memory, h.params.Time, h.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches an argon2id or bcrypt hash
+func (h *PasswordHasher) Verify(password, encoded string) (bool, error) {
+	if isBcryptHash(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(candidate, key) == 1, nil
+}
+
+// NeedsRehash reports whether a hash uses another algorithm or other
+// parameters than the hasher and should be replaced on the next login
+func (h *PasswordHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.params.Memory ||
+		params.Time != h.params.Time ||
+		params.Threads != h.params.Threads ||
+		uint32(len(salt)) != h.params.SaltLength ||
+		uint32(len(key)) != h.params.KeyLength
+}
+
+func isBcryptHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	if params.Time == 0 || params.Threads == 0 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	params.SaltLength, params.KeyLength = uint32(len(salt)), uint32(len(key))
+	return params, salt, key, nil
+}
+
+// Password policy violations
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordBreached = errors.New("password appears in a list of breached passwords, choose another one")
+)
+
+// MaxBreachedPasswords caps how many breached password hashes are held in
+// memory, roughly 100 MB at the limit. The full Have I Been Pwned corpus is
+// far larger; load a list of the most common hashes from it instead.
+const MaxBreachedPasswords = 2_000_000
+
+// ErrTooManyBreachedPasswords is returned when a breached password list has
+// more than MaxBreachedPasswords distinct hashes
+var ErrTooManyBreachedPasswords = fmt.Errorf("more than %d breached password hashes", MaxBreachedPasswords)
+
+// breachedPasswordsLimit is MaxBreachedPasswords, lowered by tests
+var breachedPasswordsLimit = MaxBreachedPasswords
+
+// PasswordPolicy decides which new passwords are accepted
+type PasswordPolicy struct {
+	MinLength int // characters
+	MaxLength int // characters
+	breached  map[[sha1.Size]byte]struct{}
+}
+
+// Check returns the first rule password violates, or nil
+func (p *PasswordPolicy) Check(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w, use at least %d characters", ErrPasswordTooShort, p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("%w, use at most %d characters", ErrPasswordTooLong, p.MaxLength)
+	}
+	if _, ok := p.breached[sha1.Sum([]byte(password))]; ok {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+// BreachedCount returns the number of loaded breached password hashes
+func (p *PasswordPolicy) BreachedCount() int {
+	return len(p.breached)
+}
+
+// LoadBreachedPasswords reads SHA-1 password hashes, one hex hash per line,
+// into the policy. Lines may carry a ":<count>" suffix as in the Have I Been
+// Pwned downloads; empty lines and lines starting with # are skipped. The
+// hashes are kept in memory, so lists with more than MaxBreachedPasswords
+// hashes are refused with ErrTooManyBreachedPasswords.
+func (p *PasswordPolicy) LoadBreachedPasswords(r io.Reader) error {
+	if p.breached == nil {
+		p.breached = make(map[[sha1.Size]byte]struct{})
+	}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if i := strings.IndexByte(text, ':'); i >= 0 {
+			text = text[:i]
+		}
+
+		var sum [sha1.Size]byte
+		if len(text) != hex.EncodedLen(sha1.Size) {
+			return fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		if _, err := hex.Decode(sum[:], []byte(text)); err != nil {
+			return fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		if _, ok := p.breached[sum]; !ok && len(p.breached) >= breachedPasswordsLimit {
+			return fmt.Errorf("line %d: %w", line, ErrTooManyBreachedPasswords)
+		}
+		p.breached[sum] = struct{}{}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,167 @@
+package crypto
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2Params = Argon2Params{Memory: 64, Time: 1, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+func TestPasswordHasherRoundTrip(t *testing.T) {
+	hasher, err := NewPasswordHasher(testArgon2Params)
+	if err != nil {
+		t.Fatalf("NewPasswordHasher() error = %v", err)
+	}
+
+	hash, err := hasher.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash() = %q, want PHC argon2id format with parameters", hash)
+	}
+
+	if ok, err := hasher.Verify("correct horse battery staple", hash); err != nil || !ok {
+		t.Errorf("Verify(correct) = %v, %v, want true", ok, err)
+	}
+	if ok, err := hasher.Verify("wrong password", hash); err != nil || ok {
+		t.Errorf("Verify(wrong) = %v, %v, want false", ok, err)
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("NeedsRehash() = true for a hash with current parameters")
+	}
+
+	other, _ := hasher.Hash("correct horse battery staple")
+	if other == hash {
+		t.Error("Hash() reused a salt")
+	}
+}
+
+func TestPasswordHasherParameterUpgrade(t *testing.T) {
+	old, _ := NewPasswordHasher(testArgon2Params)
+	hash, _ := old.Hash("secret password")
+
+	stronger := testArgon2Params
+	stronger.Time = 2
+	hasher, _ := NewPasswordHasher(stronger)
+
+	if ok, err := hasher.Verify("secret password", hash); err != nil || !ok {
+		t.Errorf("Verify() with old parameters = %v, %v, want true", ok, err)
+	}
+	if !hasher.NeedsRehash(hash) {
+		t.Error("NeedsRehash() = false for a hash with outdated parameters")
+	}
+}
+
+func TestPasswordHasherBcrypt(t *testing.T) {
+	hasher, _ := NewPasswordHasher(testArgon2Params)
+	legacy, err := bcrypt.GenerateFromPassword([]byte("legacy password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := hasher.Verify("legacy password", string(legacy)); err != nil || !ok {
+		t.Errorf("Verify(bcrypt) = %v, %v, want true", ok, err)
+	}
+	if ok, err := hasher.Verify("other password", string(legacy)); err != nil || ok {
+		t.Errorf("Verify(bcrypt, wrong) = %v, %v, want false", ok, err)
+	}
+	if !hasher.NeedsRehash(string(legacy)) {
+		t.Error("NeedsRehash() = false for a bcrypt hash")
+	}
+}
+
+func TestPasswordHasherRejectsMalformed(t *testing.T) {
+	hasher, _ := NewPasswordHasher(testArgon2Params)
+	for _, encoded := range []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$not base64!$a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ",
+	} {
+		if ok, err := hasher.Verify("password", encoded); ok || !errors.Is(err, ErrUnknownPasswordHash) {
+			t.Errorf("Verify(%q) = %v, %v, want ErrUnknownPasswordHash", encoded, ok, err)
+		}
+	}
+}
+
+func TestNewPasswordHasherValidatesParams(t *testing.T) {
+	for _, params := range []Argon2Params{
+		{Memory: 64, Time: 0, Threads: 1, SaltLength: 16, KeyLength: 32},
+		{Memory: 64, Time: 1, Threads: 0, SaltLength: 16, KeyLength: 32},
+		{Memory: 4, Time: 1, Threads: 1, SaltLength: 16, KeyLength: 32},
+		{Memory: 64, Time: 1, Threads: 1, SaltLength: 4, KeyLength: 32},
+	} {
+		if _, err := NewPasswordHasher(params); err == nil {
+			t.Errorf("NewPasswordHasher(%+v) succeeded, want error", params)
+		}
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	breachedSum := sha1.Sum([]byte("password123"))
+	list := "# top breached passwords\n" +
+		strings.ToUpper(hex.EncodeToString(breachedSum[:])) + ":2254650\n" +
+		"\n" +
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n"
+
+	policy := &PasswordPolicy{MinLength: 8, MaxLength: 16}
+	if err := policy.LoadBreachedPasswords(strings.NewReader(list)); err != nil {
+		t.Fatalf("LoadBreachedPasswords() error = %v", err)
+	}
+	if policy.BreachedCount() != 2 {
+		t.Errorf("BreachedCount() = %d, want 2", policy.BreachedCount())
+	}
+
+	tests := []struct {
+		password string
+		want     error
+	}{
+		{"short", ErrPasswordTooShort},
+		{"kurzes€", ErrPasswordTooShort},
+		{"this one is far too long", ErrPasswordTooLong},
+		{"password123", ErrPasswordBreached},
+		{"unlisted pass", nil},
+		{"schlüssel", nil},
+	}
+	for _, tt := range tests {
+		if err := policy.Check(tt.password); !errors.Is(err, tt.want) {
+			t.Errorf("Check(%q) = %v, want %v", tt.password, err, tt.want)
+		}
+	}
+}
+
+func TestLoadBreachedPasswordsRejectsGarbage(t *testing.T) {
+	policy := &PasswordPolicy{}
+	if err := policy.LoadBreachedPasswords(strings.NewReader("not-a-hash\n")); err == nil {
+		t.Error("LoadBreachedPasswords() accepted a malformed line")
+	}
+}
+
+func TestLoadBreachedPasswordsLimit(t *testing.T) {
+	defer func(limit int) { breachedPasswordsLimit = limit }(breachedPasswordsLimit)
+	breachedPasswordsLimit = 2
+
+	var list strings.Builder
+	for _, password := range []string{"one", "two", "one", "three"} {
+		sum := sha1.Sum([]byte(password))
+		list.WriteString(hex.EncodeToString(sum[:]) + "\n")
+	}
+
+	policy := &PasswordPolicy{}
+	err := policy.LoadBreachedPasswords(strings.NewReader(list.String()))
+	if !errors.Is(err, ErrTooManyBreachedPasswords) {
+		t.Fatalf("LoadBreachedPasswords() error = %v, want %v", err, ErrTooManyBreachedPasswords)
+	}
+	if !strings.HasPrefix(err.Error(), "line 4:") {
+		t.Errorf("LoadBreachedPasswords() error = %q, want it to name line 4", err)
+	}
+}
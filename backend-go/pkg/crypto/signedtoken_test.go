@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSignFieldsRoundTrip(t *testing.T) {
+	secret := []byte("test-secret")
+	fields := []string{"5b0e7c1e-8f43-4d4e-9c3a-1f2d3e4f5a6b", "3", "with.dots and spaces"}
+
+	token := SignFields(secret, "profile-link", fields...)
+	got, err := VerifyFields(secret, "profile-link", token)
+	if err != nil {
+		t.Fatalf("VerifyFields() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, fields) {
+		t.Errorf("VerifyFields() = %q, want %q", got, fields)
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("token %q is not URL-safe", token)
+	}
+}
+
+func TestVerifyFieldsRejects(t *testing.T) {
+	secret := []byte("test-secret")
+	token := SignFields(secret, "profile-link", "user-1", "1")
+	forged := SignFields(secret, "profile-link", "user-2", "1")
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		purpose string
+		token   string
+	}{
+		{"wrong secret", []byte("other-secret"), "profile-link", token},
+		{"wrong purpose", secret, "email-verification", token},
+		{"swapped payload", secret, "profile-link", forged[:strings.LastIndex(forged, ".")] + token[strings.LastIndex(token, "."):]},
+		{"truncated", secret, "profile-link", token[:len(token)-2]},
+		{"no separator", secret, "profile-link", "abc"},
+		{"empty", secret, "profile-link", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := VerifyFields(tt.secret, tt.purpose, tt.token); err != ErrInvalidSignedToken {
+				t.Errorf("VerifyFields() error = %v, want ErrInvalidSignedToken", err)
+			}
+		})
+	}
+}
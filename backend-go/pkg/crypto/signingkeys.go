@@ -0,0 +1,299 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported token signing algorithms (JOSE names)
+const (
+	AlgEdDSA = "EdDSA"
+	AlgES256 = "ES256"
+)
+
+// PEM headers carried by signing key files
+const (
+	pemHeaderKeyID       = "Key-Id"
+	pemHeaderActivatesAt = "Activates-At"
+)
+
+// SigningKey is an asymmetric token signing key identified by a key ID. A key
+// is used for signing from ActivatesAt until a newer key activates.
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  crypto.Signer
+	ActivatesAt time.Time
+}
+
+// PublicKey returns the verification key
+func (k *SigningKey) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+// GenerateSigningKey creates a new Ed25519 (EdDSA) or P-256 (ES256) key
+func GenerateSigningKey(algorithm, id string, activatesAt time.Time) (*SigningKey, error) {
+	var signer crypto.Signer
+	switch algorithm {
+	case AlgEdDSA:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signer = priv
+	case AlgES256:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signer = priv
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	return &SigningKey{ID: id, Algorithm: algorithm, PrivateKey: signer, ActivatesAt: activatesAt}, nil
+}
+
+// EncodeSigningKeyPEM encodes a key as a PKCS#8 PEM block with Key-Id and
+// Activates-At headers
+func EncodeSigningKeyPEM(key *SigningKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type: "PRIVATE KEY",
+		Headers: map[string]string{
+			pemHeaderKeyID:       key.ID,
+			pemHeaderActivatesAt: key.ActivatesAt.UTC().Format(time.RFC3339),
+		},
+		Bytes: der,
+	}), nil
+}
+
+// ParseSigningKeysPEM parses every PEM private key in data. The key ID comes
+// from the Key-Id header, or defaultID for blocks without one. A missing Activates-At header means the key is active immediately.
+func ParseSigningKeysPEM(data []byte, defaultID string) ([]*SigningKey, error) {
+	var keys []*SigningKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		id := block.Headers[pemHeaderKeyID]
+		if id == "" {
+			id = defaultID
+		}
+		if id == "" {
+			return nil, fmt.Errorf("signing key without %s header", pemHeaderKeyID)
+		}
+
+		var activatesAt time.Time
+		if value := block.Headers[pemHeaderActivatesAt]; value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: invalid %s: %w", id, pemHeaderActivatesAt, err)
+			}
+			activatesAt = t
+		}
+
+		var parsed interface{}
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			parsed, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			return nil, fmt.Errorf("key %s: unsupported PEM type %q", id, block.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+
+		key := &SigningKey{ID: id, ActivatesAt: activatesAt}
+		switch priv := parsed.(type) {
+		case ed25519.PrivateKey:
+			key.Algorithm, key.PrivateKey = AlgEdDSA, priv
+		case *ecdsa.PrivateKey:
+			if priv.Curve != elliptic.P256() {
+				return nil, fmt.Errorf("key %s: only P-256 EC keys are supported", id)
+			}
+			key.Algorithm, key.PrivateKey = AlgES256, priv
+		default:
+			return nil, fmt.Errorf("key %s: unsupported key type %T", id, parsed)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// LoadSigningKeysDir reads every *.pem file in dir. Files without a Key-Id
+// header use their file name (without extension) as key ID.
+func LoadSigningKeysDir(dir string) ([]*SigningKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*SigningKey
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fileKeys, err := ParseSigningKeysPEM(data, strings.TrimSuffix(filepath.Base(path), ".pem"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return keys, nil
+}
+
+// KeyRing holds the signing keys of a rotation schedule. The newest activated
+// key signs; a superseded key keeps verifying for the overlap period so tokens
+// it issued stay valid until they expire. Keys that are not yet active are
+// published ahead of time so verifiers can cache them.
+type KeyRing struct {
+	mu      sync.RWMutex
+	keys    []*SigningKey // sorted by ActivatesAt
+	overlap time.Duration
+}
+
+// NewKeyRing creates a key ring. overlap should be at least the lifetime of
+// the longest-lived token signed with the ring.
+func NewKeyRing(keys []*SigningKey, overlap time.Duration) (*KeyRing, error) {
+	r := &KeyRing{overlap: overlap}
+	if err := r.SetKeys(keys); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetKeys replaces the keys of the ring, e.g. after reloading key files
+func (r *KeyRing) SetKeys(keys []*SigningKey) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no signing keys")
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key.ID] {
+			return fmt.Errorf("duplicate signing key ID %q", key.ID)
+		}
+		seen[key.ID] = true
+	}
+
+	sorted := append([]*SigningKey(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActivatesAt.Before(sorted[j].ActivatesAt)
+	})
+
+	r.mu.Lock()
+	r.keys = sorted
+	r.mu.Unlock()
+	return nil
+}
+
+// Current returns the key that signs at time now
+func (r *KeyRing) Current(now time.Time) (*SigningKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		if !r.keys[i].ActivatesAt.After(now) {
+			return r.keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no signing key active")
+}
+
+// Lookup returns the published key with the given ID
+func (r *KeyRing) Lookup(id string, now time.Time) (*SigningKey, bool) {
+	for _, key := range r.Published(now) {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Published returns every key that is current, upcoming, or superseded less
+// than the overlap period ago
+func (r *KeyRing) Published(now time.Time) []*SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var published []*SigningKey
+	for i, key := range r.keys {
+		if i+1 < len(r.keys) {
+			successor := r.keys[i+1]
+			if !successor.ActivatesAt.After(now) && now.Sub(successor.ActivatesAt) > r.overlap {
+				continue // retired
+			}
+		}
+		published = append(published, key)
+	}
+	return published
+}
+
+// JWK is a public JSON Web Key (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y,omitempty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+// JWKSet is a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys published at time now
+func (r *KeyRing) JWKS(now time.Time) JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range r.Published(now) {
+		if jwk, err := PublicJWK(key); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// PublicJWK encodes the public half of a signing key as a JWK
+func PublicJWK(key *SigningKey) (JWK, error) {
+	jwk := JWK{KeyID: key.ID, Algorithm: key.Algorithm, Use: "sig"}
+	switch pub := key.PublicKey().(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	case *ecdsa.PublicKey:
+		jwk.KeyType, jwk.Curve = "EC", "P-256"
+		x, y := make([]byte, 32), make([]byte, 32)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		jwk.X = base64.RawURLEncoding.EncodeToString(x)
+		jwk.Y = base64.RawURLEncoding.EncodeToString(y)
+	default:
+		return JWK{}, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return jwk, nil
+}
@@ -0,0 +1,149 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestSigningKeyPEMRoundTrip(t *testing.T) {
+	activatesAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, alg := range []string{AlgEdDSA, AlgES256} {
+		key, err := GenerateSigningKey(alg, "key-"+alg, activatesAt)
+		if err != nil {
+			t.Fatalf("GenerateSigningKey(%s) error = %v", alg, err)
+		}
+
+		data, err := EncodeSigningKeyPEM(key)
+		if err != nil {
+			t.Fatalf("EncodeSigningKeyPEM(%s) error = %v", alg, err)
+		}
+
+		keys, err := ParseSigningKeysPEM(data, "")
+		if err != nil {
+			t.Fatalf("ParseSigningKeysPEM(%s) error = %v", alg, err)
+		}
+		if len(keys) != 1 {
+			t.Fatalf("ParseSigningKeysPEM(%s) returned %d keys, want 1", alg, len(keys))
+		}
+
+		got := keys[0]
+		if got.ID != key.ID || got.Algorithm != alg || !got.ActivatesAt.Equal(activatesAt) {
+			t.Errorf("ParseSigningKeysPEM(%s) = %s/%s/%v, want %s/%s/%v",
+				alg, got.ID, got.Algorithm, got.ActivatesAt, key.ID, alg, activatesAt)
+		}
+	}
+}
+
+func TestParseSigningKeysPEMDefaultID(t *testing.T) {
+	key, _ := GenerateSigningKey(AlgEdDSA, "", time.Time{})
+	data, _ := EncodeSigningKeyPEM(key)
+
+	if _, err := ParseSigningKeysPEM(data, ""); err == nil {
+		t.Error("ParseSigningKeysPEM() without key ID should fail")
+	}
+
+	keys, err := ParseSigningKeysPEM(data, "from-file")
+	if err != nil {
+		t.Fatalf("ParseSigningKeysPEM() error = %v", err)
+	}
+	if keys[0].ID != "from-file" {
+		t.Errorf("ParseSigningKeysPEM() ID = %s, want from-file", keys[0].ID)
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	overlap := 24 * time.Hour
+
+	old, _ := GenerateSigningKey(AlgEdDSA, "old", t0)
+	next, _ := GenerateSigningKey(AlgES256, "next", t0.Add(30*24*time.Hour))
+
+	ring, err := NewKeyRing([]*SigningKey{next, old}, overlap)
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		current   string
+		published []string
+	}{
+		{"before rotation", t0.Add(time.Hour), "old", []string{"old", "next"}},
+		{"within overlap", next.ActivatesAt.Add(time.Hour), "next", []string{"old", "next"}},
+		{"after overlap", next.ActivatesAt.Add(overlap + time.Hour), "next", []string{"next"}},
+	}
+
+	for _, tt := range tests {
+		current, err := ring.Current(tt.now)
+		if err != nil {
+			t.Fatalf("%s: Current() error = %v", tt.name, err)
+		}
+		if current.ID != tt.current {
+			t.Errorf("%s: Current() = %s, want %s", tt.name, current.ID, tt.current)
+		}
+
+		published := ring.Published(tt.now)
+		if len(published) != len(tt.published) {
+			t.Fatalf("%s: Published() returned %d keys, want %d", tt.name, len(published), len(tt.published))
+		}
+		for i, key := range published {
+			if key.ID != tt.published[i] {
+				t.Errorf("%s: Published()[%d] = %s, want %s", tt.name, i, key.ID, tt.published[i])
+			}
+		}
+	}
+
+	if _, ok := ring.Lookup("old", next.ActivatesAt.Add(overlap+time.Hour)); ok {
+		t.Error("Lookup() should not return a retired key")
+	}
+	if _, err := ring.Current(t0.Add(-time.Hour)); err == nil {
+		t.Error("Current() before any key activates should fail")
+	}
+}
+
+func TestKeyRingDuplicateID(t *testing.T) {
+	a, _ := GenerateSigningKey(AlgEdDSA, "same", time.Time{})
+	b, _ := GenerateSigningKey(AlgEdDSA, "same", time.Time{})
+
+	if _, err := NewKeyRing([]*SigningKey{a, b}, time.Hour); err == nil {
+		t.Error("NewKeyRing() with duplicate IDs should fail")
+	}
+	if _, err := NewKeyRing(nil, time.Hour); err == nil {
+		t.Error("NewKeyRing() without keys should fail")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	ed, _ := GenerateSigningKey(AlgEdDSA, "ed", time.Time{})
+	ec, _ := GenerateSigningKey(AlgES256, "ec", time.Time{}.Add(time.Hour))
+
+	ring, _ := NewKeyRing([]*SigningKey{ed, ec}, time.Hour)
+	set := ring.JWKS(time.Time{})
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS() returned %d keys, want 2", len(set.Keys))
+	}
+
+	for _, jwk := range set.Keys {
+		if jwk.Use != "sig" {
+			t.Errorf("JWK %s use = %s, want sig", jwk.KeyID, jwk.Use)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			t.Errorf("JWK %s x is not base64url: %v", jwk.KeyID, err)
+		}
+		switch jwk.KeyID {
+		case "ed":
+			if jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.Algorithm != AlgEdDSA || len(x) != 32 || jwk.Y != "" {
+				t.Errorf("unexpected Ed25519 JWK %+v", jwk)
+			}
+		case "ec":
+			y, _ := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if jwk.KeyType != "EC" || jwk.Curve != "P-256" || jwk.Algorithm != AlgES256 || len(x) != 32 || len(y) != 32 {
+				t.Errorf("unexpected P-256 JWK %+v", jwk)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package crypto
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B test vectors (SHA-1), truncated to 6 digits
+func TestGenerateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := GenerateTOTP(secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Errorf("GenerateTOTP() error = %v", err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("GenerateTOTP(%d) = %v, want %v", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() error = %v", err)
+	}
+
+	now := time.Now()
+	code, _ := GenerateTOTP(secret, TOTPStep(now.Add(-TOTPPeriod*time.Second)))
+
+	step, ok := ValidateTOTP(secret, code, now, 1)
+	if !ok {
+		t.Error("ValidateTOTP() should accept code from previous step")
+	}
+	if step != TOTPStep(now)-1 {
+		t.Errorf("ValidateTOTP() step = %v, want %v", step, TOTPStep(now)-1)
+	}
+
+	if _, ok := ValidateTOTP(secret, code, now, 0); ok {
+		t.Error("ValidateTOTP() should reject code outside skew window")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("SnapTalker", "+911234567890", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/SnapTalker:") {
+		t.Errorf("TOTPURI() = %v, want otpauth://totp/SnapTalker: prefix", uri)
+	}
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") {
+		t.Errorf("TOTPURI() = %v, missing secret", uri)
+	}
+}
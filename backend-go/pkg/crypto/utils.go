@@ -161,3 +161,12 @@ func SecureCompare(a, b []byte) bool {
 	}
 	return result == 0
 }
+
+// GenerateRandomToken generates a URL-safe random token from n random bytes
+func GenerateRandomToken(n int) (string, error) {
+	bytes, err := GenerateRandomBytes(n)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -155,6 +155,23 @@ func TestSecureCompare(t *testing.T) {
 	}
 }
 
+func TestGenerateRandomToken(t *testing.T) {
+	token1, err := GenerateRandomToken(32)
+	if err != nil {
+		t.Errorf("GenerateRandomToken() error = %v", err)
+		return
+	}
+	token2, _ := GenerateRandomToken(32)
+
+	if len(token1) != 43 {
+		t.Errorf("GenerateRandomToken() length = %v, want 43", len(token1))
+	}
+
+	if token1 == token2 {
+		t.Error("GenerateRandomToken() should produce different tokens")
+	}
+}
+
 // Benchmarks
 
 func BenchmarkGenerateRandomBytes(b *testing.B) {
@@ -0,0 +1,107 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func solid(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestSquareThumbnailSize(t *testing.T) {
+	tests := []struct {
+		w, h, size int
+	}{
+		{1000, 500, 96},
+		{300, 800, 640},
+		{50, 50, 96},
+		{1, 1, 96},
+	}
+
+	for _, tt := range tests {
+		thumb := SquareThumbnail(solid(tt.w, tt.h, color.RGBA{10, 20, 30, 255}), tt.size)
+		if thumb.Bounds().Dx() != tt.size || thumb.Bounds().Dy() != tt.size {
+			t.Errorf("SquareThumbnail(%dx%d, %d) = %v", tt.w, tt.h, tt.size, thumb.Bounds())
+		}
+		if got := thumb.RGBAAt(tt.size/2, tt.size/2); got != (color.RGBA{10, 20, 30, 255}) {
+			t.Errorf("SquareThumbnail(%dx%d, %d) center = %v", tt.w, tt.h, tt.size, got)
+		}
+	}
+}
+
+func TestSquareThumbnailCropsCenter(t *testing.T) {
+	// Red bars left and right of a green square: only green must survive
+	img := solid(300, 100, color.RGBA{255, 0, 0, 255})
+	for y := 0; y < 100; y++ {
+		for x := 100; x < 200; x++ {
+			img.SetRGBA(x, y, color.RGBA{0, 255, 0, 255})
+		}
+	}
+
+	thumb := SquareThumbnail(img, 10)
+	for _, p := range []image.Point{{0, 0}, {9, 0}, {0, 9}, {9, 9}, {5, 5}} {
+		if got := thumb.RGBAAt(p.X, p.Y); got != (color.RGBA{0, 255, 0, 255}) {
+			t.Errorf("pixel %v = %v, want green", p, got)
+		}
+	}
+}
+
+func TestSquareThumbnailAverages(t *testing.T) {
+	// 2x2 checkerboard of black and white averages to mid grey
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.SetRGBA(0, 0, color.RGBA{0, 0, 0, 255})
+	img.SetRGBA(1, 1, color.RGBA{0, 0, 0, 255})
+	img.SetRGBA(1, 0, color.RGBA{255, 255, 255, 255})
+	img.SetRGBA(0, 1, color.RGBA{255, 255, 255, 255})
+
+	got := SquareThumbnail(img, 1).RGBAAt(0, 0)
+	if got.R != 127 || got.G != 127 || got.B != 127 || got.A != 255 {
+		t.Errorf("SquareThumbnail() = %v, want grey", got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, solid(20, 10, color.RGBA{1, 2, 3, 255})); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if img.Bounds().Dx() != 20 || img.Bounds().Dy() != 10 {
+		t.Errorf("Decode() bounds = %v", img.Bounds())
+	}
+
+	if _, err := Decode([]byte("not an image")); err != ErrUnsupportedFormat {
+		t.Errorf("Decode(garbage) error = %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestEncodeJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeJPEG(&buf, solid(8, 8, color.RGBA{0, 0, 0, 0}), 85); err != nil {
+		t.Fatalf("EncodeJPEG() error = %v", err)
+	}
+
+	img, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatalf("jpeg.Decode() error = %v", err)
+	}
+	// Fully transparent pixels are flattened onto white
+	if r, g, b, _ := img.At(4, 4).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("EncodeJPEG() transparent pixel = %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+}
@@ -0,0 +1,249 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is a minimal OIDC provider issuing RS256 ID tokens
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// challenges maps issued codes to their PKCE challenge and nonce
+	challenges map[string][2]string
+	claims     jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{t: t, key: key, challenges: map[string][2]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock-1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		clientID, secret, _ := r.BasicAuth()
+		issued, ok := m.challenges[r.Form.Get("code")]
+		if !ok || clientID != "client-1" || secret != "s3cret" || CodeChallenge(r.Form.Get("code_verifier")) != issued[0] {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(m.challenges, r.Form.Get("code"))
+		claims := jwt.MapClaims{"nonce": issued[1]}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.sign(claims)})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	m.claims = jwt.MapClaims{
+		"iss":            m.server.URL,
+		"aud":            "client-1",
+		"sub":            "user-42",
+		"email":          "ada@example.com",
+		"email_verified": true,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+	}
+	return m
+}
+
+func (m *mockProvider) sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "mock-1"
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed
+}
+
+// authorize simulates the browser leg: the user signs in and the provider
+// redirects back with a code
+func (m *mockProvider) authorize(authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "client-1" {
+		m.t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	m.challenges["code-1"] = [2]string{q.Get("code_challenge"), q.Get("nonce")}
+	return "code-1"
+}
+
+func (m *mockProvider) provider(t *testing.T) *Provider {
+	p, err := NewProvider(ProviderConfig{
+		Name:           "mock",
+		Issuer:         m.server.URL,
+		ClientID:       "client-1",
+		ClientSecret:   "s3cret",
+		RedirectURL:    "https://app.example/oidc/callback",
+		AllowedDomains: []string{"example.com"},
+	}, m.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider(t)
+	ctx := context.Background()
+
+	verifier, _ := NewCodeVerifier()
+	state, _ := NewState()
+	nonce, _ := NewState()
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	if !strings.HasPrefix(authURL, m.server.URL+"/authorize?") || !strings.Contains(authURL, "state="+state) {
+		t.Errorf("AuthCodeURL() = %q", authURL)
+	}
+
+	code := m.authorize(authURL)
+	idToken, err := p.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if idToken.Subject != "user-42" || idToken.Email != "ada@example.com" || !idToken.EmailVerified {
+		t.Errorf("Exchange() = %+v", idToken)
+	}
+	if !p.EmailAllowed(idToken.Email) || p.EmailAllowed("eve@evil.example") {
+		t.Error("EmailAllowed() does not enforce the allowed domains")
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider(t)
+	ctx := context.Background()
+
+	verifier, _ := NewCodeVerifier()
+	authURL, _ := p.AuthCodeURL(ctx, "state", "nonce", verifier)
+	code := m.authorize(authURL)
+
+	other, _ := NewCodeVerifier()
+	if _, err := p.Exchange(ctx, code, other, "nonce"); err == nil {
+		t.Error("Exchange() accepted a code with the wrong PKCE verifier")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider(t)
+	ctx := context.Background()
+
+	valid := func() jwt.MapClaims {
+		claims := jwt.MapClaims{"nonce": "n-1"}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		return claims
+	}
+	if _, err := p.VerifyIDToken(ctx, m.sign(valid()), "n-1"); err != nil {
+		t.Fatalf("VerifyIDToken(valid) error = %v", err)
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, valid())
+	forged.Header["kid"] = "mock-1"
+	forgedToken, _ := forged.SignedString(otherKey)
+
+	tests := []struct {
+		name   string
+		token  string
+		nonce  string
+		mutate func(jwt.MapClaims)
+	}{
+		{"wrong nonce", "", "n-2", nil},
+		{"wrong issuer", "", "n-1", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{"wrong audience", "", "n-1", func(c jwt.MapClaims) { c["aud"] = "client-2" }},
+		{"expired", "", "n-1", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"no subject", "", "n-1", func(c jwt.MapClaims) { delete(c, "sub") }},
+		{"foreign azp", "", "n-1", func(c jwt.MapClaims) { c["aud"] = []string{"client-1", "client-2"}; c["azp"] = "client-2" }},
+		{"bad signature", forgedToken, "n-1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tt.token
+			if token == "" {
+				claims := valid()
+				if tt.mutate != nil {
+					tt.mutate(claims)
+				}
+				token = m.sign(claims)
+			}
+			if _, err := p.VerifyIDToken(ctx, token, tt.nonce); !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("VerifyIDToken() error = %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestDiscoverRejectsIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+	p, _ := NewProvider(ProviderConfig{
+		Name:        "mock",
+		Issuer:      m.server.URL + "/other",
+		ClientID:    "client-1",
+		RedirectURL: "https://app.example/oidc/callback",
+	}, m.server.Client())
+	if _, err := p.Discover(context.Background()); err == nil {
+		t.Error("Discover() accepted a document for another issuer")
+	}
+}
+
+func TestCodeVerifierAndChallenge(t *testing.T) {
+	verifier, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 7636 requires 43 to 128 unreserved characters
+	if len(verifier) < 43 || len(verifier) > 128 || strings.ContainsAny(verifier, "+/=") {
+		t.Errorf("NewCodeVerifier() = %q", verifier)
+	}
+	challenge := CodeChallenge(verifier)
+	if challenge != CodeChallenge(verifier) || challenge == verifier || len(challenge) != 43 {
+		t.Errorf("CodeChallenge() = %q", challenge)
+	}
+}
@@ -57,11 +57,23 @@ func NewMinIOClient(config MinIOConfig) (*MinIOClient, error) {
 	}, nil
 }
 
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"contentType"`
+	LastModified time.Time `json:"lastModified"`
+}
+
 // Upload uploads a file to MinIO
 func (m *MinIOClient) Upload(ctx context.Context, objectName string, data []byte) (string, error) {
-	reader := bytes.NewReader(data)
-	_, err := m.client.PutObject(ctx, m.bucketName, objectName, reader, int64(len(data)), minio.PutObjectOptions{
-		ContentType: "application/octet-stream",
+	return m.UploadStream(ctx, objectName, bytes.NewReader(data), int64(len(data)), "application/octet-stream")
+}
+
+// UploadStream uploads size bytes read from reader with the given content type
+func (m *MinIOClient) UploadStream(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) (string, error) {
+	_, err := m.client.PutObject(ctx, m.bucketName, objectName, reader, size, minio.PutObjectOptions{
+		ContentType: contentType,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to upload object: %w", err)
@@ -97,6 +109,34 @@ func (m *MinIOClient) Delete(ctx context.Context, objectName string) error {
 	return nil
 }
 
+// List returns every object whose name starts with prefix
+func (m *MinIOClient) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for object := range m.client.ListObjects(ctx, m.bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", object.Err)
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          object.Key,
+			Size:         object.Size,
+			ContentType:  object.ContentType,
+			LastModified: object.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+// DeletePrefix deletes every object whose name starts with prefix
+func (m *MinIOClient) DeletePrefix(ctx context.Context, prefix string) error {
+	objects := m.client.ListObjects(ctx, m.bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	for result := range m.client.RemoveObjects(ctx, m.bucketName, objects, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			return fmt.Errorf("failed to delete object %s: %w", result.ObjectName, result.Err)
+		}
+	}
+	return nil
+}
+
 // GetPresignedURL generates a presigned URL for temporary access
 func (m *MinIOClient) GetPresignedURL(ctx context.Context, objectName string, expiry int) (string, error) {
 	url, err := m.client.PresignedGetObject(ctx, m.bucketName, objectName, time.Duration(expiry)*time.Second, nil)
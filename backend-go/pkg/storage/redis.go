@@ -80,11 +80,32 @@ func (r *RedisClient) Incr(ctx context.Context, key string) (int64, error) {
 	return r.Client.Incr(ctx, key).Result()
 }
 
+// IncrBy increments a key's value by n
+func (r *RedisClient) IncrBy(ctx context.Context, key string, n int64) (int64, error) {
+	return r.Client.IncrBy(ctx, key, n).Result()
+}
+
 // Expire sets an expiration time on a key
 func (r *RedisClient) Expire(ctx context.Context, key string, expiration time.Duration) error {
 	return r.Client.Expire(ctx, key, expiration).Err()
 }
 
+// TTL returns the remaining time to live of a key
+func (r *RedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return r.Client.TTL(ctx, key).Result()
+}
+
+// DeletePattern deletes all keys matching a glob pattern
+func (r *RedisClient) DeletePattern(ctx context.Context, pattern string) error {
+	iter := r.Client.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		if err := r.Client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
 // Close closes the Redis connection
 func (r *RedisClient) Close() error {
 	return r.Client.Close()
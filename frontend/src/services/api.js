@@ -48,6 +48,7 @@ api.interceptors.response.use(
                         refreshToken: refreshToken, // Changed to camelCase for Go backend
                     });
                     localStorage.setItem('accessToken', data.token); // Changed from access_token
+                    localStorage.setItem('refreshToken', data.refreshToken); // Refresh tokens are rotated on every use
                     api.defaults.headers.common['Authorization'] = `Bearer ${data.token}`;
                     return api(originalRequest);
                 } catch (err) {